@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+var errCancelled = errors.New("drone step cancelled")
+
+// cancelReason names the build in the stopped tasks' reason
+func (p *Plugin) cancelReason() string {
+	if len(p.Build.Repo) != 0 && len(p.Build.Number) != 0 {
+		return fmt.Sprintf("Drone build %s#%s cancelled", p.Build.Repo, p.Build.Number)
+	}
+	return "Drone step cancelled"
+}
+
+// sleep waits for the duration, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// cancelTasks stops the started tasks once the step is cancelled and waits
+// at most CancelStopTimeout seconds for them to reach STOPPED
+func (p *Plugin) cancelTasks(taskArns []string) error {
+	log.Println("Cancelled, stopping started tasks")
+	if len(taskArns) == 0 {
+		return errCancelled
+	}
+	p.stopTasks(taskArns, p.cancelReason())
+
+	deadline := time.Now().Add(time.Duration(p.CancelStopTimeout) * time.Second)
+	interval := minPollInterval
+	for time.Now().Before(deadline) {
+		out, err := p.describeTasks(taskArns)
+		if err != nil {
+			log.Println(err)
+			break
+		}
+		stopped := true
+		for _, task := range out.Tasks {
+			if aws.StringValue(task.LastStatus) != ecs.DesiredStatusStopped {
+				stopped = false
+			}
+		}
+		if stopped {
+			log.Println("All tasks stopped!")
+			return errCancelled
+		}
+		time.Sleep(interval)
+		interval = nextPollInterval(interval, true)
+	}
+
+	log.Printf("Tasks did not stop within %ds\n", p.CancelStopTimeout)
+	return errCancelled
+}
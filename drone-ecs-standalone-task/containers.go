@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+type portMappingTemplate struct {
+	ContainerPort int64  `json:"containerPort"`
+	HostPort      int64  `json:"hostPort"`
+	Protocol      string `json:"protocol"`
+}
+
+type dependsOnTemplate struct {
+	ContainerName string `json:"containerName"`
+	Condition     string `json:"condition"`
+}
+
+type logConfigurationTemplate struct {
+	LogDriver string            `json:"logDriver"`
+	Options   map[string]string `json:"options"`
+}
+
+// containerTemplate is an additional container of the task definition, as
+// declared in the `containers` setting
+type containerTemplate struct {
+	Name              string                    `json:"name"`
+	Image             string                    `json:"image"`
+	Essential         *bool                     `json:"essential"`
+	Environment       []string                  `json:"environment"`
+	PortMappings      []portMappingTemplate     `json:"portMappings"`
+	DependsOn         []dependsOnTemplate       `json:"dependsOn"`
+	LogConfiguration  *logConfigurationTemplate `json:"logConfiguration"`
+	Command           []string                  `json:"command"`
+	EntryPoint        []string                  `json:"entryPoint"`
+	CPU               int64                     `json:"cpu"`
+	Memory            int64                     `json:"memory"`
+	MemoryReservation int64                     `json:"memoryReservation"`
+}
+
+func validDependsOnCondition(condition string) bool {
+	for _, c := range ecs.ContainerCondition_Values() {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
+
+func (t containerTemplate) containerDefinition(logConfiguration *ecs.LogConfiguration) (*ecs.ContainerDefinition, error) {
+	if len(t.Name) == 0 {
+		return nil, errors.New(containersBaseParseErr + "container without name")
+	}
+	if len(t.Image) == 0 {
+		return nil, fmt.Errorf(containersBaseParseErr+"container %s: image is required", t.Name)
+	}
+
+	definition := &ecs.ContainerDefinition{
+		Name:      aws.String(t.Name),
+		Image:     aws.String(t.Image),
+		Essential: aws.Bool(true),
+	}
+	if t.Essential != nil {
+		definition.Essential = aws.Bool(*t.Essential)
+	}
+
+	for _, envVar := range t.Environment {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(containersBaseParseErr+"container %s: environment variable %q, expected NAME=VALUE", t.Name, envVar)
+		}
+		definition.Environment = append(definition.Environment, &ecs.KeyValuePair{
+			Name:  aws.String(strings.Trim(parts[0], " ")),
+			Value: aws.String(strings.Trim(parts[1], " ")),
+		})
+	}
+
+	for _, portMapping := range t.PortMappings {
+		if portMapping.ContainerPort == 0 {
+			return nil, fmt.Errorf(containersBaseParseErr+"container %s: port mapping without containerPort", t.Name)
+		}
+		pair := &ecs.PortMapping{
+			ContainerPort: aws.Int64(portMapping.ContainerPort),
+			Protocol:      aws.String("tcp"),
+		}
+		if portMapping.HostPort != 0 {
+			pair.HostPort = aws.Int64(portMapping.HostPort)
+		}
+		if len(portMapping.Protocol) != 0 {
+			pair.Protocol = aws.String(portMapping.Protocol)
+		}
+		definition.PortMappings = append(definition.PortMappings, pair)
+	}
+
+	for _, dependency := range t.DependsOn {
+		if !validDependsOnCondition(dependency.Condition) {
+			return nil, fmt.Errorf(containersBaseParseErr+"container %s: dependsOn condition %q, expected one of %v", t.Name, dependency.Condition, ecs.ContainerCondition_Values())
+		}
+		definition.DependsOn = append(definition.DependsOn, &ecs.ContainerDependency{
+			ContainerName: aws.String(dependency.ContainerName),
+			Condition:     aws.String(dependency.Condition),
+		})
+	}
+
+	if t.LogConfiguration != nil {
+		definition.LogConfiguration = &ecs.LogConfiguration{
+			LogDriver: aws.String(t.LogConfiguration.LogDriver),
+			Options:   aws.StringMap(t.LogConfiguration.Options),
+		}
+	} else if logConfiguration != nil {
+		// Share the main container's log configuration, awslogs streams
+		// are named after the container anyway
+		definition.LogConfiguration = logConfiguration
+	}
+
+	if len(t.Command) > 0 {
+		definition.Command = aws.StringSlice(t.Command)
+	}
+	if len(t.EntryPoint) > 0 {
+		definition.EntryPoint = aws.StringSlice(t.EntryPoint)
+	}
+	if t.CPU != 0 {
+		definition.Cpu = aws.Int64(t.CPU)
+	}
+	if t.Memory != 0 {
+		definition.Memory = aws.Int64(t.Memory)
+	}
+	if t.MemoryReservation != 0 {
+		definition.MemoryReservation = aws.Int64(t.MemoryReservation)
+	}
+
+	return definition, nil
+}
+
+// parseDependsOn parses the main container's `containerName CONDITION` dependencies
+func (p *Plugin) parseDependsOn() ([]*ecs.ContainerDependency, error) {
+	dependencies := []*ecs.ContainerDependency{}
+	for _, dependency := range p.DependsOn {
+		parts := strings.Fields(dependency)
+		if len(parts) != 2 {
+			return nil, errors.New(dependsOnBaseParseErr + "expected `containerName CONDITION`, got " + dependency)
+		}
+		if !validDependsOnCondition(parts[1]) {
+			return nil, fmt.Errorf(dependsOnBaseParseErr+"condition %q, expected one of %v", parts[1], ecs.ContainerCondition_Values())
+		}
+		dependencies = append(dependencies, &ecs.ContainerDependency{
+			ContainerName: aws.String(parts[0]),
+			Condition:     aws.String(parts[1]),
+		})
+	}
+	return dependencies, nil
+}
+
+// addContainers adds the containers declared in the `containers` setting to
+// the task definition, replacing existing containers with the same name
+func (p *Plugin) addContainers(params *ecs.RegisterTaskDefinitionInput, main *ecs.ContainerDefinition) error {
+	if len(p.Containers) == 0 {
+		return nil
+	}
+
+	var templates []containerTemplate
+	if err := json.Unmarshal([]byte(p.Containers), &templates); err != nil {
+		return errors.New(containersBaseParseErr + err.Error())
+	}
+
+	seen := map[string]bool{aws.StringValue(main.Name): true}
+	for _, template := range templates {
+		if seen[template.Name] {
+			return fmt.Errorf(containersBaseParseErr+"container %s is declared twice", template.Name)
+		}
+		seen[template.Name] = true
+
+		definition, err := template.containerDefinition(main.LogConfiguration)
+		if err != nil {
+			return err
+		}
+
+		replaced := false
+		for i, existing := range params.ContainerDefinitions {
+			if aws.StringValue(existing.Name) == template.Name {
+				params.ContainerDefinitions[i] = definition
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			params.ContainerDefinitions = append(params.ContainerDefinitions, definition)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, definition := range params.ContainerDefinitions {
+		names[aws.StringValue(definition.Name)] = true
+	}
+	for _, definition := range params.ContainerDefinitions {
+		for _, dependency := range definition.DependsOn {
+			if !names[aws.StringValue(dependency.ContainerName)] {
+				return fmt.Errorf(containersBaseParseErr+"container %s depends on unknown container %s", aws.StringValue(definition.Name), aws.StringValue(dependency.ContainerName))
+			}
+		}
+	}
+
+	return nil
+}
+
+// essentialContainers returns the names of the essential containers of the
+// task definition. ECS treats containers without the flag as essential
+func essentialContainers(definitions []*ecs.ContainerDefinition) map[string]bool {
+	essential := map[string]bool{}
+	for _, definition := range definitions {
+		if definition.Essential == nil || *definition.Essential {
+			essential[aws.StringValue(definition.Name)] = true
+		}
+	}
+	return essential
+}
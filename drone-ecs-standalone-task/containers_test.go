@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestAddContainersErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers string
+		expected   string
+	}{
+		{"invalid json", `{"name": "proxy"}`, containersBaseParseErr},
+		{"name", `[{"image": "example/proxy:1"}]`, "container without name"},
+		{"image", `[{"name": "proxy"}]`, "container proxy: image is required"},
+		{"environment", `[{"name": "proxy", "image": "example/proxy:1", "environment": ["MODE"]}]`, `container proxy: environment variable "MODE", expected NAME=VALUE`},
+		{"port mapping", `[{"name": "proxy", "image": "example/proxy:1", "portMappings": [{"hostPort": 80}]}]`, "container proxy: port mapping without containerPort"},
+		{"dependsOn condition", `[{"name": "proxy", "image": "example/proxy:1", "dependsOn": [{"containerName": "app", "condition": "READY"}]}]`, `container proxy: dependsOn condition "READY"`},
+		{"dependsOn container", `[{"name": "proxy", "image": "example/proxy:1", "dependsOn": [{"containerName": "db", "condition": "START"}]}]`, "container proxy depends on unknown container db"},
+		{"duplicate", `[{"name": "proxy", "image": "example/proxy:1"}, {"name": "proxy", "image": "example/proxy:2"}]`, "container proxy is declared twice"},
+		{"main container", `[{"name": "app", "image": "example/app:3"}]`, "container app is declared twice"},
+	}
+	for _, test := range tests {
+		_, p := newJob(t)
+		p.Containers = test.containers
+		_, err := p.createTaskDefinition()
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), containersBaseParseErr) || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected %q in %q", test.name, test.expected, err.Error())
+		}
+	}
+}
+
+func TestParseDependsOnErrors(t *testing.T) {
+	for _, dependsOn := range []string{"proxy", "proxy READY"} {
+		_, p := newJob(t)
+		p.DependsOn = []string{dependsOn}
+		_, err := p.createTaskDefinition()
+		if err == nil || !strings.HasPrefix(err.Error(), dependsOnBaseParseErr) {
+			t.Errorf("%q: expected a depends_on error, got %v", dependsOn, err)
+		}
+	}
+}
+
+func TestExecRegistersAdditionalContainers(t *testing.T) {
+	fake, p := newJob(t)
+	p.Containers = `[
+		{"name": "sidecar", "image": "example/sidecar:2", "environment": ["MODE=proxy"],
+		 "dependsOn": [{"containerName": "proxy", "condition": "START"}]},
+		{"name": "proxy", "image": "example/proxy:1", "essential": false,
+		 "portMappings": [{"containerPort": 8080}]}
+	]`
+	p.DependsOn = []string{"proxy START"}
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	definitions := fake.taskDefinitions["job"][1].definition.ContainerDefinitions
+	if len(definitions) != 3 {
+		t.Fatalf("expected app, sidecar and proxy, got %d containers", len(definitions))
+	}
+
+	app := findContainer(definitions, "app")
+	if len(app.DependsOn) != 1 || aws.StringValue(app.DependsOn[0].ContainerName) != "proxy" || aws.StringValue(app.DependsOn[0].Condition) != ecs.ContainerConditionStart {
+		t.Errorf("expected app to depend on proxy starting, got %v", app.DependsOn)
+	}
+
+	// the sidecar of job:1 is replaced, not merged
+	sidecar := findContainer(definitions, "sidecar")
+	if got := aws.StringValue(sidecar.Image); got != "example/sidecar:2" {
+		t.Errorf("expected the sidecar to be replaced, got image %s", got)
+	}
+	if !aws.BoolValue(sidecar.Essential) {
+		t.Error("expected the replaced sidecar to be essential by default")
+	}
+	if env := environment(sidecar); len(env) != 1 || env["MODE"] != "proxy" {
+		t.Errorf("expected the sidecar's environment, got %v", env)
+	}
+	if len(sidecar.DependsOn) != 1 || aws.StringValue(sidecar.DependsOn[0].ContainerName) != "proxy" {
+		t.Errorf("expected the sidecar to depend on proxy, got %v", sidecar.DependsOn)
+	}
+
+	proxy := findContainer(definitions, "proxy")
+	if aws.BoolValue(proxy.Essential) {
+		t.Error("expected proxy not to be essential")
+	}
+	if len(proxy.PortMappings) != 1 || aws.StringValue(proxy.PortMappings[0].Protocol) != ecs.TransportProtocolTcp {
+		t.Errorf("expected a tcp port mapping, got %v", proxy.PortMappings)
+	}
+	// containers without log configuration share the main container's
+	if proxy.LogConfiguration == nil || aws.StringValue(proxy.LogConfiguration.LogDriver) != awslogsDriver ||
+		aws.StringValue(proxy.LogConfiguration.Options[awslogsGroup]) != "/ecs/job" {
+		t.Errorf("expected the log configuration of app, got %v", proxy.LogConfiguration)
+	}
+}
+
+func TestAddContainersKeepsOwnLogConfiguration(t *testing.T) {
+	_, p := newJob(t)
+	p.Containers = `[{"name": "proxy", "image": "example/proxy:1",
+		"logConfiguration": {"logDriver": "json-file", "options": {"max-size": "10m"}}}]`
+	params, err := p.createTaskDefinition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := findContainer(params.ContainerDefinitions, "proxy")
+	if got := aws.StringValue(proxy.LogConfiguration.LogDriver); got != "json-file" {
+		t.Errorf("expected the container's log driver, got %s", got)
+	}
+	if got := aws.StringValue(proxy.LogConfiguration.Options["max-size"]); got != "10m" {
+		t.Errorf("expected the container's log options, got %s", got)
+	}
+}
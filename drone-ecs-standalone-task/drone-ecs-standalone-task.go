@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -231,6 +232,16 @@ func main() {
 			Usage:  "json array of placement constraints",
 			EnvVar: "PLUGIN_PLACEMENT_CONSTRAINTS",
 		},
+		cli.StringFlag{
+			Name:   "task-placement-constraints",
+			Usage:  "json array of placement constraints applied when running the task",
+			EnvVar: "PLUGIN_TASK_PLACEMENT_CONSTRAINTS",
+		},
+		cli.StringFlag{
+			Name:   "placement-strategy",
+			Usage:  "json array of placement strategies applied when running the task",
+			EnvVar: "PLUGIN_PLACEMENT_STRATEGY",
+		},
 
 		cli.BoolFlag{
 			Name:   "enable-execute-command",
@@ -290,11 +301,240 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "existing-task-definition-arn",
-			Usage:  "ARN of task definition to use for running standalone task",
+			Usage:  "Task definition to use for running standalone task: family, family:revision or ARN",
 			EnvVar: "PLUGIN_EXISTING_TASK_DEFINITION_ARN",
 		},
+		cli.StringFlag{
+			Name:   "task-definition-from-service",
+			Usage:  "Name of the ECS service of the cluster whose task definition is used",
+			EnvVar: "PLUGIN_TASK_DEFINITION_FROM_SERVICE",
+		},
+		cli.StringFlag{
+			Name:   "like-service",
+			Usage:  "Name of the ECS service of the cluster the task runs like, taking the settings left empty from it",
+			EnvVar: "PLUGIN_LIKE_SERVICE",
+		},
+		cli.StringFlag{
+			Name:   "singleton",
+			Usage:  "What to do with the tasks of the family's group already running: wait, fail or stop",
+			EnvVar: "PLUGIN_SINGLETON",
+		},
+		cli.BoolFlag{
+			Name:   "idempotent",
+			Usage:  "Attach to the tasks already started by a previous attempt of the step instead of starting them again",
+			EnvVar: "PLUGIN_IDEMPOTENT",
+		},
+		cli.Int64Flag{
+			Name:   "run-task-retry-timeout",
+			Usage:  "Time in seconds to retry starting tasks ECS could not place for lack of capacity. Default 120",
+			Value:  120,
+			EnvVar: "PLUGIN_RUN_TASK_RETRY_TIMEOUT",
+		},
+		cli.Int64Flag{
+			Name:   "run-task-retry-backoff",
+			Usage:  "Initial time in seconds between the attempts to start tasks, doubled after each attempt. Default 5",
+			Value:  5,
+			EnvVar: "PLUGIN_RUN_TASK_RETRY_BACKOFF",
+		},
+		cli.Int64Flag{
+			Name:   "cancel-stop-timeout",
+			Usage:  "Time in seconds to wait for the tasks to stop when the step is cancelled, below Docker's 10 seconds stop grace period. Default 8",
+			Value:  8,
+			EnvVar: "PLUGIN_CANCEL_STOP_TIMEOUT",
+		},
+		cli.StringSliceFlag{
+			Name:   "deciding-containers",
+			Usage:  "Containers whose exit codes decide whether the step succeeds. Defaults to the essential containers",
+			EnvVar: "PLUGIN_DECIDING_CONTAINERS",
+		},
+		cli.BoolFlag{
+			Name:   "propagate-exit-code",
+			Usage:  "Exit with the exit code of the failed container instead of 1",
+			EnvVar: "PLUGIN_PROPAGATE_EXIT_CODE",
+		},
+		cli.BoolTFlag{
+			Name:   "reuse-task-definition",
+			Usage:  "Reuse the latest revision of the family instead of registering a new one when they are identical",
+			EnvVar: "PLUGIN_REUSE_TASK_DEFINITION",
+		},
+		cli.Int64Flag{
+			Name:   "keep-revisions",
+			Usage:  "Number of ACTIVE revisions of the family kept after a successful run, older ones are deregistered. 0 disables pruning",
+			EnvVar: "PLUGIN_KEEP_REVISIONS",
+		},
+		cli.BoolFlag{
+			Name:   "prune-dry-run",
+			Usage:  "Only list the revisions which would be deregistered",
+			EnvVar: "PLUGIN_PRUNE_DRY_RUN",
+		},
+		cli.BoolFlag{
+			Name:   "dry-run",
+			Usage:  "Print the task definition and run task requests instead of sending them",
+			EnvVar: "PLUGIN_DRY_RUN",
+		},
+		cli.StringFlag{
+			Name:   "settings",
+			Usage:  "yaml or json document of structured settings",
+			EnvVar: "PLUGIN_SETTINGS",
+		},
+		cli.StringFlag{
+			Name:   "settings-file",
+			Usage:  "yaml or json file of structured settings",
+			EnvVar: "PLUGIN_SETTINGS_FILE",
+		},
+		cli.StringFlag{
+			Name:   "task-definition-file",
+			Usage:  "json or yaml task definition file the settings are applied on top of",
+			EnvVar: "PLUGIN_TASK_DEFINITION_FILE",
+		},
+		cli.StringFlag{
+			Name:   "containers",
+			Usage:  "json array of additional containers of the task definition",
+			EnvVar: "PLUGIN_CONTAINERS",
+		},
+		cli.StringSliceFlag{
+			Name:   "depends-on",
+			Usage:  "Dependencies of the container on the additional containers, format is \"containerName CONDITION\"",
+			EnvVar: "PLUGIN_DEPENDS_ON",
+		},
+		cli.BoolFlag{
+			Name:   "stream-logs",
+			Usage:  "Stream awslogs of the task's containers into the step output",
+			EnvVar: "PLUGIN_STREAM_LOGS",
+		},
+		cli.StringFlag{
+			Name:   "override-container-name",
+			Usage:  "Container the container overrides apply to. Defaults to container-name",
+			EnvVar: "PLUGIN_OVERRIDE_CONTAINER_NAME",
+		},
+		cli.StringSliceFlag{
+			Name:   "override-command",
+			Usage:  "Command overriding the container's command for this run",
+			EnvVar: "PLUGIN_OVERRIDE_COMMAND",
+		},
+		cli.StringSliceFlag{
+			Name:   "override-environment-variables",
+			Usage:  "Environment variables added to or overriding the container's environment for this run",
+			EnvVar: "PLUGIN_OVERRIDE_ENVIRONMENT_VARIABLES",
+		},
+		cli.Int64Flag{
+			Name:   "override-cpu",
+			Usage:  "The number of cpu units reserved for the container for this run",
+			EnvVar: "PLUGIN_OVERRIDE_CPU",
+		},
+		cli.Int64Flag{
+			Name:   "override-memory",
+			Usage:  "The hard limit (in MiB) of memory of the container for this run",
+			EnvVar: "PLUGIN_OVERRIDE_MEMORY",
+		},
+		cli.Int64Flag{
+			Name:   "override-memory-reservation",
+			Usage:  "The soft limit (in MiB) of memory of the container for this run",
+			EnvVar: "PLUGIN_OVERRIDE_MEMORY_RESERVATION",
+		},
+		cli.StringFlag{
+			Name:   "override-task-cpu",
+			Usage:  "The number of CPU units used by the task for this run",
+			EnvVar: "PLUGIN_OVERRIDE_TASK_CPU",
+		},
+		cli.StringFlag{
+			Name:   "override-task-memory",
+			Usage:  "The amount of memory (in MiB) used by the task for this run",
+			EnvVar: "PLUGIN_OVERRIDE_TASK_MEMORY",
+		},
+		cli.StringFlag{
+			Name:   "override-task-role-arn",
+			Usage:  "ECS task IAM role for this run",
+			EnvVar: "PLUGIN_OVERRIDE_TASK_ROLE_ARN",
+		},
+		cli.StringFlag{
+			Name:   "override-execution-role-arn",
+			Usage:  "ECS task execution IAM role for this run",
+			EnvVar: "PLUGIN_OVERRIDE_EXECUTION_ROLE_ARN",
+		},
+		cli.StringSliceFlag{
+			Name:   "tags",
+			Usage:  "Tags added to the started tasks, format is KEY=VALUE",
+			EnvVar: "PLUGIN_TAGS",
+		},
+		cli.BoolTFlag{
+			Name:   "build-tags",
+			Usage:  "Tag the started tasks with the Drone build metadata",
+			EnvVar: "PLUGIN_BUILD_TAGS",
+		},
+		cli.StringFlag{
+			Name:   "started-by",
+			Usage:  "startedBy of the started tasks. Defaults to drone-<repo>-<build number>",
+			EnvVar: "PLUGIN_STARTED_BY",
+		},
+		cli.Int64Flag{
+			Name:   "shards",
+			Usage:  "Number of tasks started, each with SHARD_INDEX and SHARD_TOTAL in its environment",
+			EnvVar: "PLUGIN_SHARDS",
+		},
+		cli.StringSliceFlag{
+			Name:   "shard-values",
+			Usage:  "Start a task per value, passed to the task as SHARD_VALUE",
+			EnvVar: "PLUGIN_SHARD_VALUES",
+		},
+		cli.Int64Flag{
+			Name:   "infrastructure-retries",
+			Usage:  "Number of times tasks stopped for an infrastructure reason (spot interruption, image pull or network setup failure) are run again",
+			EnvVar: "PLUGIN_INFRASTRUCTURE_RETRIES",
+		},
+		cli.Int64Flag{
+			Name:   "infrastructure-retry-backoff",
+			Usage:  "Initial delay in seconds before tasks are run again, doubled at every attempt",
+			Value:  10,
+			EnvVar: "PLUGIN_INFRASTRUCTURE_RETRY_BACKOFF",
+		},
+		cli.StringFlag{
+			Name:   "targets",
+			Usage:  "json array of the clusters (region, cluster, user_role_arn, service_network_subnets, service_network_security_groups) to run the task in concurrently",
+			EnvVar: "PLUGIN_TARGETS",
+		},
+		cli.BoolFlag{
+			Name:   "fail-fast",
+			Usage:  "Cancel the tasks of the other targets once one fails",
+			EnvVar: "PLUGIN_FAIL_FAST",
+		},
+		cli.StringFlag{
+			Name:   "repo",
+			Usage:  "Drone repository name",
+			EnvVar: "DRONE_REPO",
+		},
+		cli.StringFlag{
+			Name:   "commit-sha",
+			Usage:  "Drone commit SHA",
+			EnvVar: "DRONE_COMMIT_SHA",
+		},
+		cli.StringFlag{
+			Name:   "build-number",
+			Usage:  "Drone build number",
+			EnvVar: "DRONE_BUILD_NUMBER",
+		},
+		cli.StringFlag{
+			Name:   "build-link",
+			Usage:  "Drone build link",
+			EnvVar: "DRONE_BUILD_LINK",
+		},
+		cli.StringFlag{
+			Name:   "commit-author",
+			Usage:  "Drone commit author",
+			EnvVar: "DRONE_COMMIT_AUTHOR",
+		},
+		cli.StringFlag{
+			Name:   "step-name",
+			Usage:  "Drone step name",
+			EnvVar: "DRONE_STEP_NAME",
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) {
+			log.Println(exitErr)
+			os.Exit(exitErr.Code)
+		}
 		log.Fatal(err)
 	}
 }
@@ -342,6 +582,8 @@ func run(c *cli.Context) error {
 		Volumes:                      c.StringSlice("volumes"),
 		EfsVolumes:                   c.StringSlice("efs-volumes"),
 		PlacementConstraints:         c.String("placement-constraints"),
+		TaskPlacementConstraints:     c.String("task-placement-constraints"),
+		PlacementStrategy:            c.String("placement-strategy"),
 
 		CapacityProviders:         c.StringSlice("capacity-providers"),
 		EnableExecuteCommand:      c.Bool("enable-execute-command"),
@@ -355,6 +597,53 @@ func run(c *cli.Context) error {
 		Privileged:                c.Bool("privileged"),
 		UseExistingTaskDefinition: c.BoolT("use-existing-task-definition"),
 		ExistingTaskDefinitionArn: c.String("existing-task-definition-arn"),
+		TaskDefinitionFromService: c.String("task-definition-from-service"),
+		RunTaskRetryTimeout:       c.Int64("run-task-retry-timeout"),
+		RunTaskRetryBackoff:       c.Int64("run-task-retry-backoff"),
+		CancelStopTimeout:         c.Int64("cancel-stop-timeout"),
+		DecidingContainers:        c.StringSlice("deciding-containers"),
+		PropagateExitCode:         c.Bool("propagate-exit-code"),
+		ReuseTaskDefinition:       c.BoolT("reuse-task-definition"),
+		KeepRevisions:             c.Int64("keep-revisions"),
+		PruneDryRun:               c.Bool("prune-dry-run"),
+		DryRun:                    c.Bool("dry-run"),
+		Settings:                  c.String("settings"),
+		SettingsFile:              c.String("settings-file"),
+		TaskDefinitionFile:        c.String("task-definition-file"),
+		Containers:                c.String("containers"),
+		DependsOn:                 c.StringSlice("depends-on"),
+		StreamLogs:                c.Bool("stream-logs"),
+		OverrideContainerName:     c.String("override-container-name"),
+		OverrideCommand:           c.StringSlice("override-command"),
+		OverrideEnvironment:       c.StringSlice("override-environment-variables"),
+		OverrideCPU:               c.Int64("override-cpu"),
+		OverrideMemory:            c.Int64("override-memory"),
+		OverrideMemoryReservation: c.Int64("override-memory-reservation"),
+		OverrideTaskCPU:           c.String("override-task-cpu"),
+		OverrideTaskMemory:        c.String("override-task-memory"),
+		OverrideTaskRoleArn:       c.String("override-task-role-arn"),
+		OverrideExecutionRoleArn:  c.String("override-execution-role-arn"),
+		Tags:                      c.StringSlice("tags"),
+		BuildTags:                 c.BoolT("build-tags"),
+		StartedBy:                 c.String("started-by"),
+		Targets:                   c.String("targets"),
+		FailFast:                  c.Bool("fail-fast"),
+		Shards:                    c.Int64("shards"),
+		ShardValues:               c.StringSlice("shard-values"),
+		Build: Build{
+			Repo:   c.String("repo"),
+			Commit: c.String("commit-sha"),
+			Number: c.String("build-number"),
+			Link:   c.String("build-link"),
+			Author: c.String("commit-author"),
+			Step:   c.String("step-name"),
+		},
+
+		InfrastructureRetries:      c.Int64("infrastructure-retries"),
+		InfrastructureRetryBackoff: c.Int64("infrastructure-retry-backoff"),
+		LikeService:                c.String("like-service"),
+		Singleton:                  c.String("singleton"),
+		Idempotent:                 c.Bool("idempotent"),
 	}
 	return plugin.Exec()
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const redacted = "********"
+
+// secretEnvironmentNames returns the names of the container environment
+// variables holding values of drone secrets
+func (p *Plugin) secretEnvironmentNames() map[string]bool {
+	names := map[string]bool{}
+	settings, err := p.taskSettings()
+	if err != nil {
+		return names
+	}
+	for name := range settings.SecretEnvironment {
+		names[name] = true
+	}
+	return names
+}
+
+// redactEnvironment replaces the values of secret variables in every
+// `environment` list of the document, i.e. of container definitions and
+// container overrides
+func redactEnvironment(document interface{}, secretNames map[string]bool) {
+	switch value := document.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if list, ok := child.([]interface{}); ok && key == "environment" {
+				for _, item := range list {
+					if pair, ok := item.(map[string]interface{}); ok {
+						if name, ok := pair["name"].(string); ok && secretNames[name] {
+							pair["value"] = redacted
+						}
+					}
+				}
+				continue
+			}
+			redactEnvironment(child, secretNames)
+		}
+	case []interface{}:
+		for _, child := range value {
+			redactEnvironment(child, secretNames)
+		}
+	}
+}
+
+// dryRunJSON renders an AWS API input the way it is sent to AWS, indented
+// and with the secret environment variables redacted
+func dryRunJSON(input interface{}, secretNames map[string]bool) (string, error) {
+	body, err := jsonutil.BuildJSON(input)
+	if err != nil {
+		return "", err
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return "", err
+	}
+	redactEnvironment(document, secretNames)
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// printDryRun prints the requests the plugin would send. registerInput is
+// nil when an existing task definition is used
+func (p *Plugin) printDryRun(registerInput interface{}, runInputs ...*ecs.RunTaskInput) error {
+	secretNames := p.secretEnvironmentNames()
+
+	if registerInput != nil {
+		body, err := dryRunJSON(registerInput, secretNames)
+		if err != nil {
+			return err
+		}
+		fmt.Println("RegisterTaskDefinition request:")
+		fmt.Println(body)
+	}
+
+	for _, runInput := range runInputs {
+		body, err := dryRunJSON(runInput, secretNames)
+		if err != nil {
+			return err
+		}
+		fmt.Println("RunTask request:")
+		fmt.Println(body)
+	}
+
+	return nil
+}
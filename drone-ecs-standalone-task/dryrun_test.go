@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what fn prints to the standard output
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		output <- string(out)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+func TestExecDryRunRedactsSecrets(t *testing.T) {
+	t.Setenv("DRONE_DB_PASSWORD", "s3cr3t-value")
+	fake, p := newJob(t)
+	p.DryRun = true
+	p.SecretEnvironment = []string{"DB_PASSWORD=DRONE_DB_PASSWORD"}
+	p.OverrideEnvironment = []string{"DB_PASSWORD=s3cr3t-override", "LEVEL=debug"}
+
+	var err error
+	out := captureStdout(t, func() { err = p.Exec() })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "RegisterTaskDefinition request:") || !strings.Contains(out, "RunTask request:") {
+		t.Errorf("expected both requests to be printed, got %s", out)
+	}
+	if strings.Contains(out, "s3cr3t") {
+		t.Errorf("expected the secret values to be redacted, got %s", out)
+	}
+	if !strings.Contains(out, `"name": "DB_PASSWORD"`) || !strings.Contains(out, redacted) {
+		t.Errorf("expected the secret variable with a redacted value, got %s", out)
+	}
+	if !strings.Contains(out, `"value": "debug"`) {
+		t.Errorf("expected other variables to be printed, got %s", out)
+	}
+	// job:1 was registered by the fixture
+	if fake.calls["RegisterTaskDefinition"] != 1 || fake.calls["RunTask"] != 0 {
+		t.Errorf("expected nothing to be registered or run, got %v", fake.calls)
+	}
+}
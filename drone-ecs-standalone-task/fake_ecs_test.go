@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+)
+
+const fakeArnPrefix = "arn:aws:ecs:eu-west-1:123456789012:"
+
+type fakeRevision struct {
+	definition *ecs.TaskDefinition
+	tags       []*ecs.Tag
+}
+
+// fakeECS is an in-memory ECS simulating task definitions, services and the
+// lifecycle of tasks. Every DescribeTasks call moves the tasks one status
+// further: PROVISIONING, PENDING, RUNNING, STOPPED
+type fakeECS struct {
+	ecsiface.ECSAPI
+
+	mu              sync.Mutex
+	taskDefinitions map[string][]*fakeRevision
+	services        map[string]*ecs.Service
+	tasks           map[string]*ecs.Task
+	taskOrder       []string
+	nextTask        int
+
+	// ExitCodes of the containers of stopped tasks, by container name, 0 by default
+	exitCodes map[string]int64
+	// keepRunning tasks never stop by themselves
+	keepRunning bool
+	// runFailures are returned by the next RunTask calls instead of starting tasks
+	runFailures [][]*ecs.Failure
+	// pullFailures is the number of next tasks stopping with a
+	// CannotPullContainerError instead of running
+	pullFailures int
+	// missingClusters make RunTask fail with ClusterNotFoundException
+	missingClusters map[string]bool
+	// shortArns make RunTask fail on tagged tasks, like accounts without
+	// the long ARN format
+	shortArns bool
+
+	calls     map[string]int
+	runInputs []*ecs.RunTaskInput
+	// clientTokens are the outputs of the RunTask calls by client token
+	clientTokens map[string]*fakeRunTask
+}
+
+type fakeRunTask struct {
+	count int64
+	out   *ecs.RunTaskOutput
+}
+
+func newFakeECS() *fakeECS {
+	return &fakeECS{
+		taskDefinitions: map[string][]*fakeRevision{},
+		services:        map[string]*ecs.Service{},
+		tasks:           map[string]*ecs.Task{},
+		exitCodes:       map[string]int64{},
+		missingClusters: map[string]bool{},
+		calls:           map[string]int{},
+		clientTokens:    map[string]*fakeRunTask{},
+	}
+}
+
+func clientException(message string) error {
+	return awserr.New(ecs.ErrCodeClientException, message, nil)
+}
+
+func copyTags(tags []*ecs.Tag) []*ecs.Tag {
+	copied := []*ecs.Tag{}
+	for _, tag := range tags {
+		copied = append(copied, &ecs.Tag{Key: aws.String(aws.StringValue(tag.Key)), Value: aws.String(aws.StringValue(tag.Value))})
+	}
+	return copied
+}
+
+func (f *fakeECS) call(name string) {
+	f.calls[name]++
+}
+
+// revision finds a task definition by family, family:revision or ARN
+func (f *fakeECS) revision(taskDefinition string) (*fakeRevision, error) {
+	name := revisionName(taskDefinition)
+	parts := strings.SplitN(name, ":", 2)
+	revisions := f.taskDefinitions[parts[0]]
+	if len(parts) == 2 {
+		number, err := strconv.Atoi(parts[1])
+		if err != nil || number < 1 || number > len(revisions) {
+			return nil, clientException("Unable to describe task definition.")
+		}
+		return revisions[number-1], nil
+	}
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if aws.StringValue(revisions[i].definition.Status) == ecs.TaskDefinitionStatusActive {
+			return revisions[i], nil
+		}
+	}
+	return nil, clientException("Unable to describe task definition.")
+}
+
+func (f *fakeECS) RegisterTaskDefinition(input *ecs.RegisterTaskDefinitionInput) (*ecs.RegisterTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("RegisterTaskDefinition")
+
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	family := aws.StringValue(input.Family)
+	number := len(f.taskDefinitions[family]) + 1
+	definition := &ecs.TaskDefinition{}
+	awsutil.Copy(definition, taskDefinitionFromInput(input))
+	definition.Revision = aws.Int64(int64(number))
+	definition.Status = aws.String(ecs.TaskDefinitionStatusActive)
+	definition.TaskDefinitionArn = aws.String(fmt.Sprintf("%stask-definition/%s:%d", fakeArnPrefix, family, number))
+
+	revision := &fakeRevision{definition: definition}
+	revision.tags = copyTags(input.Tags)
+	f.taskDefinitions[family] = append(f.taskDefinitions[family], revision)
+
+	out := &ecs.RegisterTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{}}
+	awsutil.Copy(out.TaskDefinition, definition)
+	return out, nil
+}
+
+func (f *fakeECS) DescribeTaskDefinition(input *ecs.DescribeTaskDefinitionInput) (*ecs.DescribeTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("DescribeTaskDefinition")
+
+	revision, err := f.revision(aws.StringValue(input.TaskDefinition))
+	if err != nil {
+		return nil, err
+	}
+	out := &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{}}
+	awsutil.Copy(out.TaskDefinition, revision.definition)
+	if len(input.Include) > 0 {
+		out.Tags = copyTags(revision.tags)
+	}
+	return out, nil
+}
+
+func (f *fakeECS) ListTaskDefinitionsPages(input *ecs.ListTaskDefinitionsInput, fn func(*ecs.ListTaskDefinitionsOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("ListTaskDefinitions")
+
+	arns := []string{}
+	for family, revisions := range f.taskDefinitions {
+		if !strings.HasPrefix(family, aws.StringValue(input.FamilyPrefix)) {
+			continue
+		}
+		for _, revision := range revisions {
+			if input.Status == nil || aws.StringValue(revision.definition.Status) == aws.StringValue(input.Status) {
+				arns = append(arns, aws.StringValue(revision.definition.TaskDefinitionArn))
+			}
+		}
+	}
+	sort.Slice(arns, func(i, j int) bool {
+		a := strings.SplitN(revisionName(arns[i]), ":", 2)
+		b := strings.SplitN(revisionName(arns[j]), ":", 2)
+		ra, _ := strconv.Atoi(a[1])
+		rb, _ := strconv.Atoi(b[1])
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if aws.StringValue(input.Sort) == ecs.SortOrderDesc {
+			return ra > rb
+		}
+		return ra < rb
+	})
+	fn(&ecs.ListTaskDefinitionsOutput{TaskDefinitionArns: aws.StringSlice(arns)}, true)
+	return nil
+}
+
+func (f *fakeECS) DeregisterTaskDefinition(input *ecs.DeregisterTaskDefinitionInput) (*ecs.DeregisterTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("DeregisterTaskDefinition")
+
+	revision, err := f.revision(aws.StringValue(input.TaskDefinition))
+	if err != nil {
+		return nil, err
+	}
+	revision.definition.Status = aws.String(ecs.TaskDefinitionStatusInactive)
+	return &ecs.DeregisterTaskDefinitionOutput{TaskDefinition: revision.definition}, nil
+}
+
+// addService creates a service of the cluster running the task definition
+func (f *fakeECS) addService(name string, taskDefinition string) {
+	f.services[name] = &ecs.Service{
+		ServiceName:    aws.String(name),
+		ServiceArn:     aws.String(fakeArnPrefix + "service/" + name),
+		TaskDefinition: aws.String(taskDefinition),
+		Status:         aws.String("ACTIVE"),
+		Deployments: []*ecs.Deployment{{
+			Status:         aws.String("PRIMARY"),
+			TaskDefinition: aws.String(taskDefinition),
+		}},
+	}
+}
+
+func (f *fakeECS) service(name string) *ecs.Service {
+	return f.services[revisionName(name)]
+}
+
+func (f *fakeECS) ListServicesPages(input *ecs.ListServicesInput, fn func(*ecs.ListServicesOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("ListServices")
+
+	arns := []*string{}
+	for _, service := range f.services {
+		arns = append(arns, service.ServiceArn)
+	}
+	fn(&ecs.ListServicesOutput{ServiceArns: arns}, true)
+	return nil
+}
+
+func (f *fakeECS) DescribeServices(input *ecs.DescribeServicesInput) (*ecs.DescribeServicesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("DescribeServices")
+
+	out := &ecs.DescribeServicesOutput{}
+	for _, name := range input.Services {
+		service := f.service(aws.StringValue(name))
+		if service == nil {
+			out.Failures = append(out.Failures, &ecs.Failure{Arn: name, Reason: aws.String("MISSING")})
+			continue
+		}
+		copied := &ecs.Service{}
+		awsutil.Copy(copied, service)
+		out.Services = append(out.Services, copied)
+	}
+	return out, nil
+}
+
+func (f *fakeECS) UpdateService(input *ecs.UpdateServiceInput) (*ecs.UpdateServiceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("UpdateService")
+
+	service := f.service(aws.StringValue(input.Service))
+	if service == nil {
+		return nil, awserr.New(ecs.ErrCodeServiceNotFoundException, "Service not found.", nil)
+	}
+	if input.TaskDefinition != nil {
+		service.Deployments = append([]*ecs.Deployment{{
+			Status:         aws.String("PRIMARY"),
+			TaskDefinition: input.TaskDefinition,
+		}}, service.Deployments...)
+		service.TaskDefinition = input.TaskDefinition
+	}
+	return &ecs.UpdateServiceOutput{Service: service}, nil
+}
+
+func (f *fakeECS) RunTask(input *ecs.RunTaskInput) (*ecs.RunTaskOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("RunTask")
+
+	copied := &ecs.RunTaskInput{}
+	awsutil.Copy(copied, input)
+	f.runInputs = append(f.runInputs, copied)
+
+	if previous, ok := f.clientTokens[aws.StringValue(input.ClientToken)]; ok {
+		if previous.count != aws.Int64Value(input.Count) {
+			return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "The client token was used with other parameters.", nil)
+		}
+		return previous.out, nil
+	}
+
+	if f.shortArns && len(input.Tags) != 0 {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "Long arn format must be used for tagging operations", nil)
+	}
+	if aws.Int64Value(input.Count) > 10 {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "Count must be between 1 and 10.", nil)
+	}
+	if f.missingClusters[aws.StringValue(input.Cluster)] {
+		return nil, awserr.New(ecs.ErrCodeClusterNotFoundException, "Cluster not found.", nil)
+	}
+	if len(f.runFailures) > 0 {
+		failures := f.runFailures[0]
+		f.runFailures = f.runFailures[1:]
+		return &ecs.RunTaskOutput{Failures: failures}, nil
+	}
+
+	revision, err := f.revision(aws.StringValue(input.TaskDefinition))
+	if err != nil {
+		return nil, err
+	}
+
+	count := int64(1)
+	if input.Count != nil {
+		count = *input.Count
+	}
+	out := &ecs.RunTaskOutput{}
+	for i := int64(0); i < count; i++ {
+		f.nextTask++
+		task := &ecs.Task{
+			TaskArn:           aws.String(fmt.Sprintf("%stask/%s/%032d", fakeArnPrefix, aws.StringValue(input.Cluster), f.nextTask)),
+			TaskDefinitionArn: revision.definition.TaskDefinitionArn,
+			ClusterArn:        aws.String(fakeArnPrefix + "cluster/" + aws.StringValue(input.Cluster)),
+			Group:             input.Group,
+			StartedBy:         input.StartedBy,
+			Overrides:         input.Overrides,
+			LastStatus:        aws.String("PROVISIONING"),
+			DesiredStatus:     aws.String("RUNNING"),
+			Tags:              input.Tags,
+		}
+		for _, container := range revision.definition.ContainerDefinitions {
+			task.Containers = append(task.Containers, &ecs.Container{
+				Name:       container.Name,
+				LastStatus: aws.String("PENDING"),
+			})
+		}
+		f.tasks[*task.TaskArn] = task
+		f.taskOrder = append(f.taskOrder, *task.TaskArn)
+
+		copied := &ecs.Task{}
+		awsutil.Copy(copied, task)
+		out.Tasks = append(out.Tasks, copied)
+	}
+	if input.ClientToken != nil {
+		f.clientTokens[*input.ClientToken] = &fakeRunTask{count: count, out: out}
+	}
+	return out, nil
+}
+
+// stop moves the task to STOPPED, setting the containers' exit codes
+func (f *fakeECS) stop(task *ecs.Task, reason string, exitCode func(name string) int64) {
+	now := time.Now()
+	if task.StartedAt == nil {
+		task.StartedAt = &now
+	}
+	task.StoppedAt = &now
+	task.LastStatus = aws.String(ecs.DesiredStatusStopped)
+	task.DesiredStatus = aws.String(ecs.DesiredStatusStopped)
+	task.StoppedReason = aws.String(reason)
+	for _, container := range task.Containers {
+		container.LastStatus = aws.String(ecs.DesiredStatusStopped)
+		container.ExitCode = aws.Int64(exitCode(aws.StringValue(container.Name)))
+	}
+}
+
+func (f *fakeECS) advance(task *ecs.Task) {
+	switch aws.StringValue(task.LastStatus) {
+	case "PROVISIONING":
+		if f.pullFailures > 0 {
+			f.pullFailures--
+			f.stop(task, "CannotPullContainerError: pull image manifest has been retried 5 time(s)", func(string) int64 { return -1 })
+			task.StopCode = aws.String(ecs.TaskStopCodeTaskFailedToStart)
+			for _, container := range task.Containers {
+				container.ExitCode = nil
+			}
+			return
+		}
+		task.LastStatus = aws.String("PENDING")
+	case "PENDING":
+		now := time.Now()
+		task.StartedAt = &now
+		task.LastStatus = aws.String("RUNNING")
+		for _, container := range task.Containers {
+			container.LastStatus = aws.String("RUNNING")
+		}
+	case "RUNNING":
+		if !f.keepRunning {
+			f.stop(task, "Essential container in task exited", func(name string) int64 {
+				return f.exitCodes[name]
+			})
+		}
+	}
+}
+
+func (f *fakeECS) DescribeTasks(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("DescribeTasks")
+
+	if len(input.Tasks) == 0 || len(input.Tasks) > 100 {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "Tasks must contain between 1 and 100 elements.", nil)
+	}
+
+	out := &ecs.DescribeTasksOutput{}
+	for _, arn := range input.Tasks {
+		task, ok := f.tasks[aws.StringValue(arn)]
+		if !ok {
+			out.Failures = append(out.Failures, &ecs.Failure{Arn: arn, Reason: aws.String("MISSING")})
+			continue
+		}
+		f.advance(task)
+		copied := &ecs.Task{}
+		awsutil.Copy(copied, task)
+		out.Tasks = append(out.Tasks, copied)
+	}
+	return out, nil
+}
+
+func (f *fakeECS) StopTask(input *ecs.StopTaskInput) (*ecs.StopTaskOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("StopTask")
+
+	task, ok := f.tasks[aws.StringValue(input.Task)]
+	if !ok {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "The referenced task was not found.", nil)
+	}
+	f.stop(task, aws.StringValue(input.Reason), func(string) int64 { return 137 })
+	return &ecs.StopTaskOutput{Task: task}, nil
+}
+
+func (f *fakeECS) ListTasksPages(input *ecs.ListTasksInput, fn func(*ecs.ListTasksOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("ListTasks")
+
+	arns := []*string{}
+	for _, arn := range f.taskOrder {
+		task := f.tasks[arn]
+		if aws.StringValue(task.ClusterArn) != fakeArnPrefix+"cluster/"+aws.StringValue(input.Cluster) {
+			continue
+		}
+		if input.DesiredStatus != nil && aws.StringValue(task.DesiredStatus) != aws.StringValue(input.DesiredStatus) {
+			continue
+		}
+		if input.StartedBy != nil && aws.StringValue(task.StartedBy) != aws.StringValue(input.StartedBy) {
+			continue
+		}
+		if input.Family != nil && taskDefinitionFamily(aws.StringValue(task.TaskDefinitionArn)) != aws.StringValue(input.Family) {
+			continue
+		}
+		arns = append(arns, task.TaskArn)
+	}
+	fn(&ecs.ListTasksOutput{TaskArns: arns}, true)
+	return nil
+}
+
+// taskDefinitionRevisions returns the ARNs of the family's revisions with the status
+func (f *fakeECS) taskDefinitionRevisions(family string, status string) []string {
+	arns := []string{}
+	for _, revision := range f.taskDefinitions[family] {
+		if aws.StringValue(revision.definition.Status) == status {
+			arns = append(arns, aws.StringValue(revision.definition.TaskDefinitionArn))
+		}
+	}
+	return arns
+}
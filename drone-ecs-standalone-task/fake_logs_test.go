@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+)
+
+// fakeLogs is an in-memory CloudWatch Logs returning at most pageSize events
+// per GetLogEvents call. Forward tokens are `f/<index of the next event>`
+type fakeLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+
+	mu       sync.Mutex
+	streams  map[string][]string
+	pageSize int
+	// failures are returned by GetLogEvents for the `group|stream` keys
+	failures map[string]error
+}
+
+func newFakeLogs() *fakeLogs {
+	return &fakeLogs{streams: map[string][]string{}, pageSize: 2, failures: map[string]error{}}
+}
+
+func (f *fakeLogs) write(group string, stream string, messages ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := group + "|" + stream
+	f.streams[key] = append(f.streams[key], messages...)
+}
+
+func (f *fakeLogs) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := aws.StringValue(input.LogGroupName) + "|" + aws.StringValue(input.LogStreamName)
+	if err, ok := f.failures[key]; ok {
+		return nil, err
+	}
+	messages, ok := f.streams[key]
+	if !ok {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log stream does not exist.", nil)
+	}
+
+	start := 0
+	if input.NextToken != nil {
+		index, err := strconv.Atoi(strings.TrimPrefix(aws.StringValue(input.NextToken), "f/"))
+		if err != nil {
+			return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "The specified nextToken is invalid.", nil)
+		}
+		start = index
+	}
+	end := start + f.pageSize
+	if end > len(messages) {
+		end = len(messages)
+	}
+
+	out := &cloudwatchlogs.GetLogEventsOutput{
+		NextForwardToken: aws.String(fmt.Sprintf("f/%d", end)),
+	}
+	for _, message := range messages[start:end] {
+		out.Events = append(out.Events, &cloudwatchlogs.OutputLogEvent{Message: aws.String(message)})
+	}
+	return out, nil
+}
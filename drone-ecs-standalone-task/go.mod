@@ -3,8 +3,9 @@ module bm/drone-ecs-standalone-task
 go 1.19
 
 require (
-	github.com/aws/aws-sdk-go v1.44.198
+	github.com/aws/aws-sdk-go v1.55.8
 	github.com/urfave/cli v1.22.12
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
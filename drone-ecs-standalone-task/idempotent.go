@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const launchIdentityPrefix = "drone-"
+
+// launchIdentity identifies the tasks of the step of the build, staying the
+// same across attempts of the step. It is the startedBy, referenceId and
+// client token of the tasks, short enough for their 64 characters limits
+func (p *Plugin) launchIdentity() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{p.Build.Repo, p.Build.Number, p.Build.Step}, "/")))
+	return launchIdentityPrefix + hex.EncodeToString(sum[:16])
+}
+
+// clientToken derives the client token of a RunTask call from the token of
+// its input, so every call of an attempt has its own token, the same in
+// every attempt of the step. Nil without token, the SDK then generates one
+func clientToken(token *string, key string) *string {
+	if token == nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(aws.StringValue(token) + "/" + key))
+	return aws.String(launchIdentityPrefix + hex.EncodeToString(sum[:16]))
+}
+
+// launchedTasks returns the tasks a previous attempt of the step started in
+// the cluster which are still running. Tasks it started and which already
+// stopped are returned by ECS again for the same client token
+func (p *Plugin) launchedTasks() ([]*ecs.Task, error) {
+	arns := []string{}
+	err := p.ecsService.ListTasksPages(&ecs.ListTasksInput{
+		Cluster:       aws.String(p.Cluster),
+		StartedBy:     aws.String(p.launchIdentity()),
+		DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+	}, func(page *ecs.ListTasksOutput, lastPage bool) bool {
+		arns = append(arns, aws.StringValueSlice(page.TaskArns)...)
+		return true
+	})
+	if err != nil || len(arns) == 0 {
+		return nil, err
+	}
+
+	out, err := p.describeTasks(arns)
+	if err != nil {
+		return nil, err
+	}
+	return out.Tasks, nil
+}
+
+// taskShard reads the shard index of the task from the environment of its overrides
+func taskShard(task *ecs.Task) (int64, bool) {
+	if task.Overrides == nil {
+		return 0, false
+	}
+	for _, containerOverride := range task.Overrides.ContainerOverrides {
+		for _, pair := range containerOverride.Environment {
+			if aws.StringValue(pair.Name) == shardIndexVariable {
+				index, err := strconv.ParseInt(aws.StringValue(pair.Value), 10, 64)
+				return index, err == nil
+			}
+		}
+	}
+	return 0, false
+}
+
+// attachLaunchedTasks finds the tasks a previous attempt of the step
+// started and returns them with the inputs of the tasks still to start: the
+// shards without task, nil for the others. Without shards, unless every task
+// is still running, the calls of the previous attempt are made again with
+// their client tokens, so ECS returns the tasks they started, even stopped
+// ones, instead of starting new tasks
+func (p *Plugin) attachLaunchedTasks(runInputs []*ecs.RunTaskInput) ([]*ecs.Task, []*ecs.RunTaskInput, error) {
+	launched, err := p.launchedTasks()
+	if err != nil || len(launched) == 0 {
+		return nil, runInputs, err
+	}
+
+	if p.shardCount() == 0 && int64(len(launched)) < aws.Int64Value(runInputs[0].Count) {
+		log.Printf("%d task(s) started by a previous attempt as %s still running, replaying its calls\n", len(launched), p.launchIdentity())
+		return nil, runInputs, nil
+	}
+	log.Printf("Attaching to %d task(s) started by a previous attempt as %s\n", len(launched), p.launchIdentity())
+	if p.shardCount() == 0 {
+		return launched, nil, nil
+	}
+
+	p.shards = map[string]int64{}
+	toStart := append([]*ecs.RunTaskInput{}, runInputs...)
+	for _, task := range launched {
+		if shard, ok := taskShard(task); ok && shard < int64(len(toStart)) {
+			p.shards[aws.StringValue(task.TaskArn)] = shard
+			toStart[shard] = nil
+		}
+	}
+	return launched, toStart, nil
+}
+
+// startTasks starts the tasks of the inputs, attaching to the tasks already
+// started by a previous attempt of the step when Idempotent is set
+func (p *Plugin) startTasks(ctx context.Context, runInputs []*ecs.RunTaskInput) ([]*ecs.Task, error) {
+	var tasks []*ecs.Task
+	toStart := runInputs
+	if p.Idempotent {
+		var err error
+		tasks, toStart, err = p.attachLaunchedTasks(runInputs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.shardCount() > 0 {
+		started, shards, err := p.runShards(ctx, toStart)
+		if p.shards == nil {
+			p.shards = map[string]int64{}
+		}
+		for arn, shard := range shards {
+			p.shards[arn] = shard
+		}
+		return append(tasks, started...), err
+	}
+	if len(toStart) == 0 {
+		return tasks, nil
+	}
+	started, err := p.runTasks(ctx, toStart[0])
+	return append(tasks, started...), err
+}
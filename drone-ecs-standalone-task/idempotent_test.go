@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestLaunchIdentityIsStablePerStep(t *testing.T) {
+	_, p := newJob(t, idempotent())
+	identity := p.launchIdentity()
+	if len(identity) != 38 || !strings.HasPrefix(identity, launchIdentityPrefix) {
+		t.Errorf("expected a 38 characters identity, got %s", identity)
+	}
+	if _, again := newJob(t, idempotent()); again.launchIdentity() != identity {
+		t.Errorf("expected the same identity for the same step, got %s and %s", identity, again.launchIdentity())
+	}
+	p.Build.Step = "seed"
+	if p.launchIdentity() == identity {
+		t.Error("expected another identity for another step")
+	}
+}
+
+func TestExecIdempotentReplaysPreviousAttempt(t *testing.T) {
+	fake, p := newJob(t, idempotent())
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	input := fake.runInputs[0]
+	identity := p.launchIdentity()
+	if aws.StringValue(input.StartedBy) != identity || aws.StringValue(input.ReferenceId) != identity {
+		t.Errorf("expected startedBy and referenceId %s, got %s and %s", identity, aws.StringValue(input.StartedBy), aws.StringValue(input.ReferenceId))
+	}
+	if input.ClientToken == nil {
+		t.Fatal("expected a client token")
+	}
+
+	// the step is retried
+	_, p = newJob(t, onFake(fake), idempotent())
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(fake.runInputs[1].ClientToken) != aws.StringValue(input.ClientToken) {
+		t.Errorf("expected the same client token, got %s and %s", aws.StringValue(input.ClientToken), aws.StringValue(fake.runInputs[1].ClientToken))
+	}
+	if len(fake.tasks) != 1 {
+		t.Errorf("expected the task not to be started again, got %d tasks", len(fake.tasks))
+	}
+	for _, result := range p.results {
+		if result.TaskArn != fake.taskOrder[0] {
+			t.Errorf("expected the results of the previous task, got %s", result.TaskArn)
+		}
+	}
+
+	// another step of the build starts its own task
+	_, p = newJob(t, onFake(fake), idempotent())
+	p.Build.Step = "seed"
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.tasks) != 2 {
+		t.Errorf("expected the task of another step to be started, got %d tasks", len(fake.tasks))
+	}
+}
+
+func TestExecIdempotentReplaysWhenPreviousTaskStopped(t *testing.T) {
+	fake, p := newJob(t, idempotent())
+	fake.keepRunning = true
+	p.DesiredCount = 2
+	p.DontWait = true
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	// one task of the previous attempt already stopped, the other still runs
+	if _, err := fake.StopTask(&ecs.StopTaskInput{Task: aws.String(fake.taskOrder[0])}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the step is retried
+	fake.keepRunning = false
+	_, p = newJob(t, onFake(fake), idempotent())
+	p.DesiredCount = 2
+	err := p.Exec()
+	// the stopped task was killed, but it is not run again
+	if err == nil {
+		t.Error("expected the stopped task to fail the step")
+	}
+	if len(fake.tasks) != 2 {
+		t.Errorf("expected no task to be started again, got %d tasks", len(fake.tasks))
+	}
+	if aws.StringValue(fake.runInputs[1].ClientToken) != aws.StringValue(fake.runInputs[0].ClientToken) {
+		t.Errorf("expected the call of the previous attempt to be replayed, got tokens %s and %s", aws.StringValue(fake.runInputs[0].ClientToken), aws.StringValue(fake.runInputs[1].ClientToken))
+	}
+}
+
+func TestExecIdempotentGivesEveryCallItsToken(t *testing.T) {
+	fake, p := newJob(t, idempotent())
+	p.Shards = 3
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[string]bool{}
+	for _, input := range fake.runInputs {
+		token := aws.StringValue(input.ClientToken)
+		if len(token) == 0 || len(token) > 64 || tokens[token] {
+			t.Errorf("expected a distinct token of at most 64 characters, got %q", token)
+		}
+		tokens[token] = true
+	}
+	if len(tokens) != 3 {
+		t.Errorf("expected a token per shard, got %d", len(tokens))
+	}
+}
+
+func TestExecIdempotentReplaysInfrastructureRetries(t *testing.T) {
+	fake, _ := newJob(t)
+	fake.pullFailures = 1
+	for attempt := 1; attempt <= 2; attempt++ {
+		_, p := newJob(t, onFake(fake), idempotent())
+		p.InfrastructureRetries = 1
+		if err := p.Exec(); err != nil {
+			t.Fatalf("attempt %d: %v", attempt, err)
+		}
+		// the failed task and the one run again
+		if len(fake.tasks) != 2 {
+			t.Errorf("attempt %d: expected 2 tasks, got %d", attempt, len(fake.tasks))
+		}
+	}
+}
+
+func TestExecIdempotentStartsMissingShards(t *testing.T) {
+	fake, p := newJob(t, idempotent())
+	p.Shards = 3
+
+	// a previous attempt only started shard 1
+	override, err := p.shardOverride(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fake.RunTask(&ecs.RunTaskInput{
+		Cluster:        aws.String("main"),
+		TaskDefinition: aws.String("job:1"),
+		StartedBy:      aws.String(p.launchIdentity()),
+		Overrides:      override,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.runInputs) != 3 {
+		t.Fatalf("expected the two missing shards to be started, got %d RunTask calls", len(fake.runInputs))
+	}
+	for i, expected := range []string{"0", "2"} {
+		env := overrideEnvironment(fake.runInputs[i+1].Overrides, "app")
+		if env[shardIndexVariable] != expected {
+			t.Errorf("expected shard %s to be started, got %s", expected, env[shardIndexVariable])
+		}
+	}
+	shards := map[string]bool{}
+	for _, result := range p.results {
+		shards[result.Shard] = true
+	}
+	for _, shard := range []string{"0/3", "1/3", "2/3"} {
+		if !shards[shard] {
+			t.Errorf("expected a result for shard %s, got %v", shard, p.results)
+		}
+	}
+}
+
+func TestValidateIdempotent(t *testing.T) {
+	_, p := newJob(t)
+	p.Idempotent = true
+	p.StartedBy = "nightly"
+	err := p.validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"idempotent requires", "started_by can't be set"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// applyLikeService fills the settings left empty from the service named by
+// LikeService: its task definition, awsvpc network configuration, launch
+// type and platform version
+func (p *Plugin) applyLikeService() error {
+	service, err := p.describeService(p.LikeService)
+	if err != nil {
+		return errors.New(likeServiceErr + err.Error())
+	}
+	p.likeService = service
+	log.Printf("Running the task like service %s\n", p.LikeService)
+
+	if len(p.ExistingTaskDefinitionArn) == 0 && len(p.TaskDefinitionFromService) == 0 {
+		p.ExistingTaskDefinitionArn = aws.StringValue(service.TaskDefinition)
+	}
+
+	if service.NetworkConfiguration != nil && service.NetworkConfiguration.AwsvpcConfiguration != nil {
+		awsvpc := service.NetworkConfiguration.AwsvpcConfiguration
+		if len(p.NetworkMode) == 0 {
+			p.NetworkMode = ecs.NetworkModeAwsvpc
+		}
+		if len(p.ServiceNetworkSubnets) == 0 {
+			p.ServiceNetworkSubnets = aws.StringValueSlice(awsvpc.Subnets)
+		}
+		if len(p.ServiceNetworkSecurityGroups) == 0 {
+			p.ServiceNetworkSecurityGroups = aws.StringValueSlice(awsvpc.SecurityGroups)
+		}
+		if len(p.ServiceNetworkAssignPublicIP) == 0 {
+			p.ServiceNetworkAssignPublicIP = aws.StringValue(awsvpc.AssignPublicIp)
+		}
+	}
+
+	if len(p.Compatibilities) == 0 {
+		p.Compatibilities = aws.StringValue(service.LaunchType)
+	}
+	if len(p.PlatformVersion) == 0 {
+		p.PlatformVersion = aws.StringValue(service.PlatformVersion)
+	}
+	return nil
+}
+
+// likeServiceCapacityProviders returns the capacity provider strategy of
+// LikeService, unless a launch type or capacity providers are set
+func (p *Plugin) likeServiceCapacityProviders(settings *TaskSettings) []*ecs.CapacityProviderStrategyItem {
+	if p.likeService == nil || len(p.Compatibilities) != 0 || len(settings.CapacityProviders) != 0 {
+		return nil
+	}
+	strategy := []*ecs.CapacityProviderStrategyItem{}
+	for _, item := range p.likeService.CapacityProviderStrategy {
+		strategy = append(strategy, &ecs.CapacityProviderStrategyItem{
+			Base:             item.Base,
+			Weight:           item.Weight,
+			CapacityProvider: item.CapacityProvider,
+		})
+	}
+	return strategy
+}
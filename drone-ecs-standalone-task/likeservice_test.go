@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestExecRunsLikeService(t *testing.T) {
+	fake, p := newJob(t, withRevision("job"), withAwsvpcService("api"), likeService("api"))
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := fake.runInputs[0]
+	if got := aws.StringValue(input.TaskDefinition); got != fakeArnPrefix+"task-definition/job:1" {
+		t.Errorf("expected the service's job:1 to be run, got %s", got)
+	}
+	awsvpc := input.NetworkConfiguration.AwsvpcConfiguration
+	if got := aws.StringValueSlice(awsvpc.Subnets); !reflect.DeepEqual(got, []string{"subnet-a", "subnet-b"}) {
+		t.Errorf("expected the service's subnets, got %v", got)
+	}
+	if got := aws.StringValueSlice(awsvpc.SecurityGroups); !reflect.DeepEqual(got, []string{"sg-api"}) {
+		t.Errorf("expected the service's security groups, got %v", got)
+	}
+	if got := aws.StringValue(awsvpc.AssignPublicIp); got != ecs.AssignPublicIpDisabled {
+		t.Errorf("expected the service's public IP setting, got %s", got)
+	}
+	if input.LaunchType != nil || len(input.CapacityProviderStrategy) != 1 || aws.StringValue(input.CapacityProviderStrategy[0].CapacityProvider) != "FARGATE_SPOT" {
+		t.Errorf("expected the service's capacity provider strategy, got %v and launch type %v", input.CapacityProviderStrategy, input.LaunchType)
+	}
+	if got := aws.StringValue(input.PlatformVersion); got != "1.4.0" {
+		t.Errorf("expected the service's platform version, got %s", got)
+	}
+}
+
+func TestExecSettingsOverrideLikeService(t *testing.T) {
+	fake, p := newJob(t, withRevision("job"), withAwsvpcService("api"), likeService("api"))
+	p.ExistingTaskDefinitionArn = "job:2"
+	p.ServiceNetworkSecurityGroups = []string{"sg-admin"}
+	p.Compatibilities = ecs.LaunchTypeFargate
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := fake.runInputs[0]
+	if got := aws.StringValue(input.TaskDefinition); got != "job:2" {
+		t.Errorf("expected the given task definition, got %s", got)
+	}
+	awsvpc := input.NetworkConfiguration.AwsvpcConfiguration
+	if got := aws.StringValueSlice(awsvpc.SecurityGroups); !reflect.DeepEqual(got, []string{"sg-admin"}) {
+		t.Errorf("expected the given security groups, got %v", got)
+	}
+	if got := aws.StringValueSlice(awsvpc.Subnets); !reflect.DeepEqual(got, []string{"subnet-a", "subnet-b"}) {
+		t.Errorf("expected the service's subnets, got %v", got)
+	}
+	if aws.StringValue(input.LaunchType) != ecs.LaunchTypeFargate || len(input.CapacityProviderStrategy) != 0 {
+		t.Errorf("expected the given launch type, got %v and strategy %v", input.LaunchType, input.CapacityProviderStrategy)
+	}
+}
+
+func TestExecFailsOnMissingLikeService(t *testing.T) {
+	fake, p := newJob(t, likeService("api"))
+	if err := p.Exec(); err == nil {
+		t.Error("expected an error for a missing service")
+	}
+	if fake.calls["RunTask"] != 0 {
+		t.Errorf("expected no task to be started, got %d RunTask calls", fake.calls["RunTask"])
+	}
+}
+
+func TestExecValidatesSettingsOfLikeService(t *testing.T) {
+	fake, p := newJob(t, withAwsvpcService("api"), likeService("api"))
+	p.PlacementStrategy = `[{"type": "random"}]`
+
+	err := p.Exec()
+	if err == nil || !strings.Contains(err.Error(), "not supported by the FARGATE launch type") {
+		t.Fatalf("expected the placement strategy to be rejected on the service's FARGATE_SPOT, got %v", err)
+	}
+	if fake.calls["RunTask"] != 0 {
+		t.Errorf("expected no task to be started, got %d RunTask calls", fake.calls["RunTask"])
+	}
+}
+
+func TestExecRequiresSubnetsWithoutLikeServiceNetwork(t *testing.T) {
+	fake, p := newJob(t, withService("api"), likeService("api"))
+	p.NetworkMode = ecs.NetworkModeAwsvpc
+
+	err := p.Exec()
+	if err == nil || !strings.Contains(err.Error(), "service_network_subnets are required") {
+		t.Fatalf("expected the missing subnets to be reported, got %v", err)
+	}
+	if fake.calls["RunTask"] != 0 {
+		t.Errorf("expected no task to be started, got %d RunTask calls", fake.calls["RunTask"])
+	}
+}
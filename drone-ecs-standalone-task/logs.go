@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	awslogsDriver       = "awslogs"
+	awslogsGroup        = "awslogs-group"
+	awslogsStreamPrefix = "awslogs-stream-prefix"
+
+	// logPollInterval keeps GetLogEvents well below the CloudWatch Logs
+	// per-account rate limit while the task is running.
+	logPollInterval = 2 * time.Second
+)
+
+// logStream is a single container's awslogs stream followed by logTailer
+type logStream struct {
+	group     string
+	name      string
+	prefix    string
+	nextToken *string
+	disabled  bool
+}
+
+// logTailer follows the CloudWatch Logs streams of the containers of
+// started tasks and writes their lines prefixed with the container name
+type logTailer struct {
+	client   cloudwatchlogsiface.CloudWatchLogsAPI
+	out      io.Writer
+	streams  []*logStream
+	lastPoll time.Time
+	// label tells apart the lines of the targets running concurrently
+	label string
+}
+
+func newLogTailer(client cloudwatchlogsiface.CloudWatchLogsAPI, out io.Writer) *logTailer {
+	return &logTailer{
+		client: client,
+		out:    out,
+	}
+}
+
+// taskID returns the last segment of a task ARN
+func taskID(taskArn string) string {
+	parts := strings.Split(taskArn, "/")
+	return parts[len(parts)-1]
+}
+
+// addTasks registers a stream for every container of the tasks which uses
+// the awslogs driver with a stream prefix. Containers without a prefix are
+// skipped, as their stream is named after the docker container ID
+func (t *logTailer) addTasks(tasks []*ecs.Task, containers []*ecs.ContainerDefinition) {
+	for _, task := range tasks {
+		id := taskID(aws.StringValue(task.TaskArn))
+		for _, container := range containers {
+			logConfig := container.LogConfiguration
+			if logConfig == nil || aws.StringValue(logConfig.LogDriver) != awslogsDriver {
+				continue
+			}
+			group := aws.StringValue(logConfig.Options[awslogsGroup])
+			streamPrefix := aws.StringValue(logConfig.Options[awslogsStreamPrefix])
+			if len(group) == 0 || len(streamPrefix) == 0 {
+				log.Printf("Container %s has no %s or %s log option. Its logs won't be streamed\n", aws.StringValue(container.Name), awslogsGroup, awslogsStreamPrefix)
+				continue
+			}
+
+			prefix := aws.StringValue(container.Name)
+			if len(tasks) > 1 {
+				prefix = prefix + " " + id
+			}
+			if len(t.label) != 0 {
+				prefix = t.label + " " + prefix
+			}
+
+			t.streams = append(t.streams, &logStream{
+				group:  group,
+				name:   streamPrefix + "/" + aws.StringValue(container.Name) + "/" + id,
+				prefix: prefix,
+			})
+		}
+	}
+}
+
+// poll fetches new lines of every stream, at most once per logPollInterval
+func (t *logTailer) poll() {
+	if time.Since(t.lastPoll) < logPollInterval {
+		return
+	}
+	t.lastPoll = time.Now()
+	for _, stream := range t.streams {
+		t.fetch(stream, false)
+	}
+}
+
+// flush reads every stream until its end. It is called once the tasks
+// have stopped, so no lines written after the last poll are lost
+func (t *logTailer) flush() {
+	for _, stream := range t.streams {
+		t.fetch(stream, true)
+	}
+}
+
+func (t *logTailer) fetch(stream *logStream, all bool) {
+	if stream.disabled {
+		return
+	}
+	for {
+		input := &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(stream.group),
+			LogStreamName: aws.String(stream.name),
+			StartFromHead: aws.Bool(true),
+			NextToken:     stream.nextToken,
+		}
+		out, err := t.client.GetLogEvents(input)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+				// The stream is created once the container starts
+				return
+			}
+			log.Printf("Could not read log stream %s/%s, stopping streaming it: %s\n", stream.group, stream.name, err.Error())
+			stream.disabled = true
+			return
+		}
+
+		for _, event := range out.Events {
+			for _, line := range strings.Split(strings.TrimRight(aws.StringValue(event.Message), "\n"), "\n") {
+				fmt.Fprintf(t.out, "[%s] %s\n", stream.prefix, line)
+			}
+		}
+
+		// The forward token stays the same once the end of the stream is reached
+		endOfStream := out.NextForwardToken == nil || aws.StringValue(out.NextForwardToken) == aws.StringValue(stream.nextToken)
+		if out.NextForwardToken != nil {
+			stream.nextToken = out.NextForwardToken
+		}
+		if !all || endOfStream {
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func awslogsContainer(name string) *ecs.ContainerDefinition {
+	return &ecs.ContainerDefinition{
+		Name: aws.String(name),
+		LogConfiguration: &ecs.LogConfiguration{
+			LogDriver: aws.String(awslogsDriver),
+			Options: map[string]*string{
+				awslogsGroup:        aws.String("/ecs/job"),
+				awslogsStreamPrefix: aws.String("job"),
+			},
+		},
+	}
+}
+
+func TestLogTailerFlushReadsWholeStream(t *testing.T) {
+	logs := newFakeLogs()
+	logs.write("/ecs/job", "job/app/abc", "first\n", "second\nthird", "fourth")
+
+	out := &bytes.Buffer{}
+	tailer := newLogTailer(logs, out)
+	tailer.addTasks(
+		[]*ecs.Task{{TaskArn: aws.String(fakeArnPrefix + "task/main/abc")}},
+		[]*ecs.ContainerDefinition{awslogsContainer("app"), {Name: aws.String("sidecar")}},
+	)
+	tailer.flush()
+
+	expected := "[app] first\n[app] second\n[app] third\n[app] fourth\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+
+	// Only new lines are written once the end of the stream was reached
+	logs.write("/ecs/job", "job/app/abc", "fifth")
+	out.Reset()
+	tailer.flush()
+	if out.String() != "[app] fifth\n" {
+		t.Errorf("expected only the new line, got %q", out.String())
+	}
+}
+
+func TestLogTailerPrefixesTaskIDWithSeveralTasks(t *testing.T) {
+	logs := newFakeLogs()
+	logs.write("/ecs/job", "job/app/abc", "from abc")
+	logs.write("/ecs/job", "job/app/def", "from def")
+
+	out := &bytes.Buffer{}
+	tailer := newLogTailer(logs, out)
+	tailer.addTasks(
+		[]*ecs.Task{
+			{TaskArn: aws.String(fakeArnPrefix + "task/main/abc")},
+			{TaskArn: aws.String(fakeArnPrefix + "task/main/def")},
+		},
+		[]*ecs.ContainerDefinition{awslogsContainer("app")},
+	)
+	tailer.flush()
+
+	expected := "[app abc] from abc\n[app def] from def\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestLogTailerWaitsForMissingStream(t *testing.T) {
+	logs := newFakeLogs()
+
+	out := &bytes.Buffer{}
+	tailer := newLogTailer(logs, out)
+	tailer.addTasks(
+		[]*ecs.Task{{TaskArn: aws.String(fakeArnPrefix + "task/main/abc")}},
+		[]*ecs.ContainerDefinition{awslogsContainer("app")},
+	)
+	tailer.flush()
+	if out.Len() != 0 {
+		t.Fatalf("expected no output, got %q", out.String())
+	}
+
+	logs.write("/ecs/job", "job/app/abc", "started")
+	tailer.flush()
+	if out.String() != "[app] started\n" {
+		t.Errorf("expected the stream to be read once created, got %q", out.String())
+	}
+}
+
+func TestLogTailerPrefixesLabel(t *testing.T) {
+	logs := newFakeLogs()
+	logs.write("/ecs/job", "job/app/abc", "done")
+
+	out := &bytes.Buffer{}
+	tailer := newLogTailer(logs, out)
+	tailer.label = "migrate"
+	tailer.addTasks(
+		[]*ecs.Task{{TaskArn: aws.String(fakeArnPrefix + "task/main/abc")}},
+		[]*ecs.ContainerDefinition{awslogsContainer("app")},
+	)
+	tailer.flush()
+
+	if out.String() != "[migrate app] done\n" {
+		t.Errorf("expected the label before the container name, got %q", out.String())
+	}
+}
+
+func TestLogTailerSkipsContainersWithoutStreamPrefix(t *testing.T) {
+	noPrefix := awslogsContainer("worker")
+	delete(noPrefix.LogConfiguration.Options, awslogsStreamPrefix)
+	otherDriver := awslogsContainer("proxy")
+	otherDriver.LogConfiguration.LogDriver = aws.String("fluentd")
+
+	tailer := newLogTailer(newFakeLogs(), &bytes.Buffer{})
+	tailer.addTasks(
+		[]*ecs.Task{{TaskArn: aws.String(fakeArnPrefix + "task/main/abc")}},
+		[]*ecs.ContainerDefinition{awslogsContainer("app"), noPrefix, otherDriver, {Name: aws.String("sidecar")}},
+	)
+
+	if len(tailer.streams) != 1 || tailer.streams[0].name != "job/app/abc" {
+		t.Errorf("expected only the stream of app, got %+v", tailer.streams)
+	}
+}
+
+func TestLogTailerStopsStreamingOnError(t *testing.T) {
+	logs := newFakeLogs()
+	logs.write("/ecs/job", "job/app/abc", "hidden")
+	logs.failures["/ecs/job|job/app/abc"] = awserr.New("AccessDeniedException", "not authorized to perform logs:GetLogEvents", nil)
+
+	out := &bytes.Buffer{}
+	tailer := newLogTailer(logs, out)
+	tailer.addTasks(
+		[]*ecs.Task{{TaskArn: aws.String(fakeArnPrefix + "task/main/abc")}},
+		[]*ecs.ContainerDefinition{awslogsContainer("app")},
+	)
+	tailer.flush()
+	if !tailer.streams[0].disabled {
+		t.Fatal("expected the stream to be disabled")
+	}
+
+	delete(logs.failures, "/ecs/job|job/app/abc")
+	tailer.flush()
+	if out.Len() != 0 {
+		t.Errorf("expected the disabled stream not to be read again, got %q", out.String())
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const defaultTag = "latest"
+
+// imageName returns the main container's image from docker_image and tag.
+// Without a tag, the tag of docker_image is kept, otherwise latest is used
+func (p *Plugin) imageName() string {
+	if len(p.Tag) != 0 {
+		return imageWithTag(p.DockerImage, p.Tag)
+	}
+	if i := strings.LastIndex(p.DockerImage, ":"); i > strings.LastIndex(p.DockerImage, "/") {
+		return p.DockerImage
+	}
+	return p.DockerImage + ":" + defaultTag
+}
+
+// newContainerDefinition returns the main container of a task definition
+// built from the settings only, the settings are applied on top of it
+func (p *Plugin) newContainerDefinition() *ecs.ContainerDefinition {
+	return &ecs.ContainerDefinition{
+		Name:      aws.String(p.ContainerName),
+		Essential: aws.Bool(true),
+	}
+}
+
+// defaultNetworkMode returns the network mode of a new task definition:
+// awsvpc, the only one FARGATE supports, otherwise bridge like ECS does
+func (p *Plugin) defaultNetworkMode() string {
+	if p.fargate() {
+		return ecs.NetworkModeAwsvpc
+	}
+	return ecs.NetworkModeBridge
+}
+
+// checkNewTaskDefinition reports the settings a task definition built from
+// scratch is missing to be registered
+func (p *Plugin) checkNewTaskDefinition(params *ecs.RegisterTaskDefinitionInput, definition *ecs.ContainerDefinition) error {
+	errs := settingsErrors{}
+	if len(aws.StringValue(params.Family)) == 0 {
+		errs.add("family is required to register a new task definition")
+	}
+	if len(aws.StringValue(definition.Image)) == 0 {
+		errs.add("docker_image is required to register a new task definition")
+	}
+	if p.fargate() {
+		if params.Cpu == nil || params.Memory == nil {
+			errs.add("task_cpu and task_memory are required to register a new FARGATE task definition")
+		}
+	} else if params.Memory == nil && definition.Memory == nil && definition.MemoryReservation == nil {
+		errs.add("memory, memory_reservation or task_memory is required to register a new task definition")
+	}
+	return errs.err()
+}
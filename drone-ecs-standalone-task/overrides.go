@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// overrideContainerName returns the container the container level
+// overrides are applied to
+func (p *Plugin) overrideContainerName() string {
+	if len(p.OverrideContainerName) != 0 {
+		return p.OverrideContainerName
+	}
+	if len(p.ContainerName) != 0 {
+		return p.ContainerName
+	}
+	return p.Family + "-container"
+}
+
+// setupTaskOverride builds the RunTask overrides applied on top of the task
+// definition. It returns nil when no override setting is used
+func (p *Plugin) setupTaskOverride() (*ecs.TaskOverride, error) {
+	containerOverride := &ecs.ContainerOverride{}
+	hasContainerOverride := false
+
+	if len(p.OverrideCommand) > 0 {
+		containerOverride.Command = aws.StringSlice(p.OverrideCommand)
+		hasContainerOverride = true
+	}
+
+	for _, envVar := range p.OverrideEnvironment {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New(overrideEnvironmentParseErr + envVar)
+		}
+		containerOverride.Environment = append(containerOverride.Environment, &ecs.KeyValuePair{
+			Name:  aws.String(strings.Trim(parts[0], " ")),
+			Value: aws.String(strings.Trim(parts[1], " ")),
+		})
+		hasContainerOverride = true
+	}
+
+	if p.OverrideCPU != 0 {
+		containerOverride.Cpu = aws.Int64(p.OverrideCPU)
+		hasContainerOverride = true
+	}
+
+	if p.OverrideMemory != 0 {
+		containerOverride.Memory = aws.Int64(p.OverrideMemory)
+		hasContainerOverride = true
+	}
+
+	if p.OverrideMemoryReservation != 0 {
+		containerOverride.MemoryReservation = aws.Int64(p.OverrideMemoryReservation)
+		hasContainerOverride = true
+	}
+
+	override := &ecs.TaskOverride{}
+	hasOverride := false
+
+	if hasContainerOverride {
+		containerOverride.Name = aws.String(p.overrideContainerName())
+		override.ContainerOverrides = []*ecs.ContainerOverride{containerOverride}
+		hasOverride = true
+	}
+
+	if len(p.OverrideTaskCPU) != 0 {
+		override.Cpu = aws.String(p.OverrideTaskCPU)
+		hasOverride = true
+	}
+
+	if len(p.OverrideTaskMemory) != 0 {
+		override.Memory = aws.String(p.OverrideTaskMemory)
+		hasOverride = true
+	}
+
+	if len(p.OverrideTaskRoleArn) != 0 {
+		override.TaskRoleArn = aws.String(p.OverrideTaskRoleArn)
+		hasOverride = true
+	}
+
+	if len(p.OverrideExecutionRoleArn) != 0 {
+		override.ExecutionRoleArn = aws.String(p.OverrideExecutionRoleArn)
+		hasOverride = true
+	}
+
+	if !hasOverride {
+		return nil, nil
+	}
+	return override, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestExecPassesOverridesToRunTask(t *testing.T) {
+	fake, p := newJob(t)
+	p.UseExistingTaskDefinition = true
+	p.OverrideCommand = []string{"bin/console", "cache:clear"}
+	p.OverrideEnvironment = []string{"MODE=stream", " LEVEL = debug "}
+	p.OverrideCPU = 256
+	p.OverrideMemory = 1024
+	p.OverrideMemoryReservation = 512
+	p.OverrideTaskCPU = "1024"
+	p.OverrideTaskMemory = "2048"
+	p.OverrideTaskRoleArn = "arn:aws:iam::123456789012:role/job"
+	p.OverrideExecutionRoleArn = "arn:aws:iam::123456789012:role/job-execution"
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := fake.runInputs[0]
+	if got := aws.StringValue(input.TaskDefinition); got != fakeArnPrefix+"task-definition/job:1" {
+		t.Errorf("expected job:1 to be run without registering, got %s", got)
+	}
+	overrides := input.Overrides
+	if overrides == nil || len(overrides.ContainerOverrides) != 1 {
+		t.Fatalf("expected a container override, got %v", overrides)
+	}
+	container := overrides.ContainerOverrides[0]
+	// the override container defaults to container_name
+	if got := aws.StringValue(container.Name); got != "app" {
+		t.Errorf("expected the overrides of app, got %s", got)
+	}
+	if got := aws.StringValueSlice(container.Command); !reflect.DeepEqual(got, p.OverrideCommand) {
+		t.Errorf("expected command %v, got %v", p.OverrideCommand, got)
+	}
+	env := map[string]string{}
+	for _, pair := range container.Environment {
+		env[aws.StringValue(pair.Name)] = aws.StringValue(pair.Value)
+	}
+	if !reflect.DeepEqual(env, map[string]string{"MODE": "stream", "LEVEL": "debug"}) {
+		t.Errorf("expected the trimmed environment, got %v", env)
+	}
+	if aws.Int64Value(container.Cpu) != 256 || aws.Int64Value(container.Memory) != 1024 || aws.Int64Value(container.MemoryReservation) != 512 {
+		t.Errorf("expected cpu 256, memory 1024 and reservation 512, got %v", container)
+	}
+	if aws.StringValue(overrides.Cpu) != "1024" || aws.StringValue(overrides.Memory) != "2048" {
+		t.Errorf("expected task cpu 1024 and memory 2048, got %s and %s", aws.StringValue(overrides.Cpu), aws.StringValue(overrides.Memory))
+	}
+	if aws.StringValue(overrides.TaskRoleArn) != p.OverrideTaskRoleArn || aws.StringValue(overrides.ExecutionRoleArn) != p.OverrideExecutionRoleArn {
+		t.Errorf("expected the roles to be overridden, got %s and %s", aws.StringValue(overrides.TaskRoleArn), aws.StringValue(overrides.ExecutionRoleArn))
+	}
+	if fake.calls["RegisterTaskDefinition"] != 1 {
+		t.Errorf("expected nothing to be registered, got %d registrations", fake.calls["RegisterTaskDefinition"])
+	}
+}
+
+func TestExecWithoutOverrides(t *testing.T) {
+	fake, p := newJob(t)
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if overrides := fake.runInputs[0].Overrides; overrides != nil {
+		t.Errorf("expected no overrides, got %v", overrides)
+	}
+}
+
+func TestOverrideContainerName(t *testing.T) {
+	tests := []struct {
+		name     string
+		p        Plugin
+		expected string
+	}{
+		{"setting", Plugin{OverrideContainerName: "sidecar", ContainerName: "app", Family: "job"}, "sidecar"},
+		{"container_name", Plugin{ContainerName: "app", Family: "job"}, "app"},
+		{"family", Plugin{Family: "job"}, "job-container"},
+	}
+	for _, test := range tests {
+		if got := test.p.overrideContainerName(); got != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestExecOverridesOtherContainer(t *testing.T) {
+	fake, p := newJob(t)
+	p.UseExistingTaskDefinition = true
+	p.OverrideContainerName = "sidecar"
+	p.OverrideCommand = []string{"serve"}
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.StringValue(fake.runInputs[0].Overrides.ContainerOverrides[0].Name); got != "sidecar" {
+		t.Errorf("expected the overrides of sidecar, got %s", got)
+	}
+}
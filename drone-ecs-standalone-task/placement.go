@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+type placementStrategyTemplate struct {
+	Type  string `json:"type"`
+	Field string `json:"field"`
+}
+
+// setupTaskPlacementConstraints parses the run-time placement constraints.
+// Unlike the task definition ones, they may use the distinctInstance type
+func (p *Plugin) setupTaskPlacementConstraints() ([]*ecs.PlacementConstraint, error) {
+	if len(p.TaskPlacementConstraints) == 0 {
+		return nil, nil
+	}
+
+	var templates []placementConstraintsTemplate
+	if err := json.Unmarshal([]byte(p.TaskPlacementConstraints), &templates); err != nil {
+		return nil, errors.New(taskPlacementConstraintsBaseParseErr + err.Error())
+	}
+
+	constraints := []*ecs.PlacementConstraint{}
+	for i, template := range templates {
+		switch template.Type {
+		case ecs.PlacementConstraintTypeDistinctInstance:
+			if len(template.Expression) != 0 {
+				return nil, fmt.Errorf(taskPlacementConstraintsBaseParseErr+"constraint %d: %s doesn't take an expression", i, template.Type)
+			}
+		case ecs.PlacementConstraintTypeMemberOf:
+			if len(template.Expression) == 0 {
+				return nil, fmt.Errorf(taskPlacementConstraintsBaseParseErr+"constraint %d: %s requires an expression", i, template.Type)
+			}
+		default:
+			return nil, fmt.Errorf(taskPlacementConstraintsBaseParseErr+"constraint %d: unknown type %q, expected one of %v", i, template.Type, ecs.PlacementConstraintType_Values())
+		}
+
+		pc := ecs.PlacementConstraint{Type: aws.String(template.Type)}
+		if len(template.Expression) != 0 {
+			pc.Expression = aws.String(template.Expression)
+		}
+		constraints = append(constraints, &pc)
+	}
+
+	return constraints, nil
+}
+
+// setupPlacementStrategy parses the run-time placement strategies
+func (p *Plugin) setupPlacementStrategy() ([]*ecs.PlacementStrategy, error) {
+	if len(p.PlacementStrategy) == 0 {
+		return nil, nil
+	}
+
+	var templates []placementStrategyTemplate
+	if err := json.Unmarshal([]byte(p.PlacementStrategy), &templates); err != nil {
+		return nil, errors.New(placementStrategyBaseParseErr + err.Error())
+	}
+
+	strategies := []*ecs.PlacementStrategy{}
+	for i, template := range templates {
+		switch template.Type {
+		case ecs.PlacementStrategyTypeRandom:
+			if len(template.Field) != 0 {
+				return nil, fmt.Errorf(placementStrategyBaseParseErr+"strategy %d: %s doesn't take a field", i, template.Type)
+			}
+		case ecs.PlacementStrategyTypeSpread:
+			if len(template.Field) == 0 {
+				return nil, fmt.Errorf(placementStrategyBaseParseErr+"strategy %d: %s requires a field, for example instanceId or attribute:ecs.availability-zone", i, template.Type)
+			}
+		case ecs.PlacementStrategyTypeBinpack:
+			if template.Field != "cpu" && template.Field != "memory" {
+				return nil, fmt.Errorf(placementStrategyBaseParseErr+"strategy %d: %s requires field cpu or memory", i, template.Type)
+			}
+		default:
+			return nil, fmt.Errorf(placementStrategyBaseParseErr+"strategy %d: unknown type %q, expected one of %v", i, template.Type, ecs.PlacementStrategyType_Values())
+		}
+
+		ps := ecs.PlacementStrategy{Type: aws.String(template.Type)}
+		if len(template.Field) != 0 {
+			ps.Field = aws.String(template.Field)
+		}
+		strategies = append(strategies, &ps)
+	}
+
+	return strategies, nil
+}
+
+// setupPlacement parses and validates both run-time placement settings
+func (p *Plugin) setupPlacement() ([]*ecs.PlacementConstraint, []*ecs.PlacementStrategy, error) {
+	constraints, err := p.setupTaskPlacementConstraints()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	strategies, err := p.setupPlacementStrategy()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.fargate() && (len(constraints) > 0 || len(strategies) > 0) {
+		return nil, nil, errors.New("task placement constraints and placement strategies are not supported by the FARGATE launch type")
+	}
+
+	return constraints, strategies, nil
+}
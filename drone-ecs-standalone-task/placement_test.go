@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestSetupPlacementErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints string
+		strategy    string
+		expected    string
+	}{
+		{"constraints json", `{"type": "distinctInstance"}`, "", taskPlacementConstraintsBaseParseErr},
+		{"constraint type", `[{"type": "sameInstance"}]`, "", `constraint 0: unknown type "sameInstance"`},
+		{"distinctInstance expression", `[{"type": "distinctInstance", "expression": "attribute:ecs.os-type == linux"}]`, "", "constraint 0: distinctInstance doesn't take an expression"},
+		{"memberOf expression", `[{"type": "distinctInstance"}, {"type": "memberOf"}]`, "", "constraint 1: memberOf requires an expression"},
+		{"strategy json", "", `{"type": "random"}`, placementStrategyBaseParseErr},
+		{"strategy type", "", `[{"type": "pack"}]`, `strategy 0: unknown type "pack"`},
+		{"random field", "", `[{"type": "random", "field": "memory"}]`, "strategy 0: random doesn't take a field"},
+		{"spread field", "", `[{"type": "spread"}]`, "strategy 0: spread requires a field"},
+		{"binpack field", "", `[{"type": "spread", "field": "instanceId"}, {"type": "binpack", "field": "instanceId"}]`, "strategy 1: binpack requires field cpu or memory"},
+	}
+	for _, test := range tests {
+		_, p := newJob(t)
+		p.TaskPlacementConstraints = test.constraints
+		p.PlacementStrategy = test.strategy
+		_, _, err := p.setupPlacement()
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected %q in %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestSetupPlacementRejectsFargate(t *testing.T) {
+	for _, setting := range []string{"constraints", "strategy"} {
+		_, p := newJob(t)
+		p.Compatibilities = "FARGATE"
+		if setting == "constraints" {
+			p.TaskPlacementConstraints = `[{"type": "distinctInstance"}]`
+		} else {
+			p.PlacementStrategy = `[{"type": "random"}]`
+		}
+		if _, _, err := p.setupPlacement(); err == nil || !strings.Contains(err.Error(), "FARGATE") {
+			t.Errorf("%s: expected FARGATE to be rejected, got %v", setting, err)
+		}
+	}
+}
+
+func TestExecPassesPlacementToRunTask(t *testing.T) {
+	fake, p := newJob(t)
+	p.TaskPlacementConstraints = `[{"type": "distinctInstance"}, {"type": "memberOf", "expression": "attribute:ecs.instance-type =~ t3.*"}]`
+	p.PlacementStrategy = `[{"type": "spread", "field": "attribute:ecs.availability-zone"}, {"type": "binpack", "field": "memory"}]`
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := fake.runInputs[0]
+	if len(input.PlacementConstraints) != 2 {
+		t.Fatalf("expected 2 placement constraints, got %v", input.PlacementConstraints)
+	}
+	if got := aws.StringValue(input.PlacementConstraints[0].Type); got != ecs.PlacementConstraintTypeDistinctInstance || input.PlacementConstraints[0].Expression != nil {
+		t.Errorf("expected distinctInstance without expression, got %v", input.PlacementConstraints[0])
+	}
+	if got := aws.StringValue(input.PlacementConstraints[1].Expression); got != "attribute:ecs.instance-type =~ t3.*" {
+		t.Errorf("expected the memberOf expression, got %s", got)
+	}
+	if len(input.PlacementStrategy) != 2 {
+		t.Fatalf("expected 2 placement strategies, got %v", input.PlacementStrategy)
+	}
+	if got := aws.StringValue(input.PlacementStrategy[0].Field); got != "attribute:ecs.availability-zone" {
+		t.Errorf("expected spread across availability zones, got %s", got)
+	}
+	if got := aws.StringValue(input.PlacementStrategy[1].Type); got != ecs.PlacementStrategyTypeBinpack {
+		t.Errorf("expected binpack second, got %s", got)
+	}
+}
@@ -1,21 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
 )
 
 type Plugin struct {
@@ -57,6 +63,8 @@ type Plugin struct {
 	Volumes                   []string
 	EfsVolumes                []string
 	PlacementConstraints      string
+	TaskPlacementConstraints  string
+	PlacementStrategy         string
 
 	// ServiceNetworkAssignPublicIP - Whether the task's elastic network interface receives a public IP address. The default value is DISABLED.
 	ServiceNetworkAssignPublicIP string
@@ -80,9 +88,115 @@ type Plugin struct {
 	TaskKillOnTimeout         bool
 	Command                   []string
 	Privileged                bool
-	ecsService                *ecs.ECS
+	ecsService                ecsiface.ECSAPI
 	UseExistingTaskDefinition bool
 	ExistingTaskDefinitionArn string
+	TaskDefinitionFromService string
+
+	// LikeService is a service of the cluster whose task definition, network
+	// configuration, capacity provider strategy and platform version are used
+	// for the settings left empty
+	LikeService string
+	likeService *ecs.Service
+
+	// Singleton is what to do with the tasks of the group already running
+	// before the task starts: wait for them, fail, or stop them
+	Singleton string
+
+	// Idempotent attaches to the tasks a previous attempt of the step started
+	// instead of starting them again, finding them by their startedBy
+	Idempotent bool
+
+	// RunTaskRetryTimeout is the time in seconds RunTask is retried for
+	// when ECS can't place the tasks for lack of capacity
+	RunTaskRetryTimeout int64
+	RunTaskRetryBackoff int64
+
+	// CancelStopTimeout is the time in seconds to wait for the tasks to stop
+	// when the step is cancelled
+	CancelStopTimeout int64
+
+	// DecidingContainers are the containers whose exit codes decide whether
+	// the step succeeds, by default the essential ones
+	DecidingContainers []string
+
+	// PropagateExitCode makes the plugin exit with the exit code of the
+	// failed container instead of 1
+	PropagateExitCode bool
+
+	// ReuseTaskDefinition reuses the latest revision of the family instead of
+	// registering a new one when they are identical
+	ReuseTaskDefinition bool
+
+	// KeepRevisions is the number of ACTIVE revisions of the family kept
+	// after a successful run, older ones are deregistered. PruneDryRun only
+	// lists the revisions which would be deregistered. taskDefinitionArn is
+	// the revision registered or reused by the run, empty when an existing
+	// task definition is run as is
+	KeepRevisions     int64
+	PruneDryRun       bool
+	taskDefinitionArn string
+
+	// DryRun prints the RegisterTaskDefinition and RunTask requests instead
+	// of sending them
+	DryRun bool
+
+	// Settings and SettingsFile are yaml or json documents of TaskSettings
+	Settings     string
+	SettingsFile string
+	settings     *TaskSettings
+
+	// Targets is a json array of the clusters, possibly in other regions and
+	// accounts, the task runs in concurrently. FailFast cancels the other
+	// targets once one fails
+	Targets  string
+	FailFast bool
+	target   string
+	results  []containerResult
+
+	// Shards is the number of tasks started, each with its SHARD_INDEX and
+	// SHARD_TOTAL. ShardValues start a task per value, passed as SHARD_VALUE
+	Shards      int64
+	ShardValues []string
+	shards      map[string]int64
+
+	// InfrastructureRetries is the number of times tasks stopped for an
+	// infrastructure reason, i.e. a spot interruption, are run again
+	InfrastructureRetries      int64
+	InfrastructureRetryBackoff int64
+
+	// TaskDefinitionFile is a json or yaml task definition the settings are
+	// applied on top of
+	TaskDefinitionFile string
+
+	// Containers is a json array of additional containers of the task
+	// definition, DependsOn the `containerName CONDITION` dependencies of
+	// the main container
+	Containers string
+	DependsOn  []string
+
+	// StreamLogs tails the awslogs streams of the task's containers into the step output
+	StreamLogs  bool
+	logsService cloudwatchlogsiface.CloudWatchLogsAPI
+
+	// Overrides applied by RunTask on top of the task definition
+	OverrideContainerName     string
+	OverrideCommand           []string
+	OverrideEnvironment       []string
+	OverrideCPU               int64
+	OverrideMemory            int64
+	OverrideMemoryReservation int64
+	OverrideTaskCPU           string
+	OverrideTaskMemory        string
+	OverrideTaskRoleArn       string
+	OverrideExecutionRoleArn  string
+
+	// Tags are `KEY=VALUE` tags added to the started tasks, BuildTags adds
+	// the Drone build metadata as tags too
+	Tags      []string
+	BuildTags bool
+	StartedBy string
+	Build     Build
 }
 
 type placementConstraintsTemplate struct {
@@ -91,24 +205,42 @@ type placementConstraintsTemplate struct {
 }
 
 const (
-	softLimitBaseParseErr             = "error parsing ulimits softLimit: "
-	hardLimitBaseParseErr             = "error parsing ulimits hardLimit: "
-	hostPortBaseParseErr              = "error parsing port_mappings hostPort: "
-	containerBaseParseErr             = "error parsing port_mappings containerPort: "
-	minimumHealthyPercentBaseParseErr = "error parsing deployment_configuration minimumHealthyPercent: "
-	maximumPercentBaseParseErr        = "error parsing deployment_configuration maximumPercent: "
-	readOnlyBoolBaseParseErr          = "error parsing mount_points readOnly: "
-	placementConstraintsBaseParseErr  = "error parsing placement_constraints json: "
-	capProviderBaseParseErr           = "error parsing capacity_provider Base integer: "
-	weightParseErr                    = "error parsing capacity_provider Weight integer: "
-	timeoutErr                        = "error - task exceeded timeout after: "
-	taskFailedErr                     = "Task failed: "
+	softLimitBaseParseErr                = "error parsing ulimits softLimit: "
+	hardLimitBaseParseErr                = "error parsing ulimits hardLimit: "
+	hostPortBaseParseErr                 = "error parsing port_mappings hostPort: "
+	containerBaseParseErr                = "error parsing port_mappings containerPort: "
+	minimumHealthyPercentBaseParseErr    = "error parsing deployment_configuration minimumHealthyPercent: "
+	maximumPercentBaseParseErr           = "error parsing deployment_configuration maximumPercent: "
+	readOnlyBoolBaseParseErr             = "error parsing mount_points readOnly: "
+	placementConstraintsBaseParseErr     = "error parsing placement_constraints json: "
+	taskPlacementConstraintsBaseParseErr = "error parsing task_placement_constraints json: "
+	placementStrategyBaseParseErr        = "error parsing placement_strategy json: "
+	capProviderBaseParseErr              = "error parsing capacity_provider Base integer: "
+	weightParseErr                       = "error parsing capacity_provider Weight integer: "
+	timeoutErr                           = "error - task exceeded timeout after: "
+	dryRunTaskDefinition                 = "<new revision of the task definition>"
+	runTaskFailedErr                     = "error running task: "
+	describeTasksFailedErr               = "error describing tasks: "
+	taskFailedErr                        = "Task failed: "
+	overrideEnvironmentParseErr          = "error parsing override_environment_variables, expected NAME=VALUE: "
+	taskDefinitionFileErr                = "error reading task_definition_file: "
+	settingsErr                          = "invalid settings: "
+	containersBaseParseErr               = "error parsing containers json: "
+	dependsOnBaseParseErr                = "error parsing depends_on: "
+	tagsParseErr                         = "error parsing tags, expected KEY=VALUE: "
+	targetsParseErr                      = "error parsing targets json: "
+	targetsFailedErr                     = "task failed in targets: "
+	resolveTaskDefinitionErr             = "error resolving the task definition: "
+	likeServiceErr                       = "error describing like_service: "
+	singletonErr                         = "singleton: "
+	containerNotFoundErr                 = "container not found: "
+	shardContainerErr                    = "error choosing the container of the shards: "
 )
 
 func (p *Plugin) setupServiceNetworkConfiguration() *ecs.NetworkConfiguration {
 	netConfig := ecs.NetworkConfiguration{AwsvpcConfiguration: &ecs.AwsVpcConfiguration{}}
 
-	if p.NetworkMode != ecs.NetworkModeAwsvpc {
+	if p.effectiveNetworkMode() != ecs.NetworkModeAwsvpc {
 		return nil
 	}
 
@@ -155,12 +287,71 @@ func (p *Plugin) Connect() {
 		arnCredentials := stscreds.NewCredentials(sess, p.UserRoleArn)
 		awsConfigArn.Credentials = arnCredentials
 		p.ecsService = ecs.New(sess, &awsConfigArn)
+		p.logsService = cloudwatchlogs.New(sess, &awsConfigArn)
 	} else {
 		p.ecsService = ecs.New(sess)
+		p.logsService = cloudwatchlogs.New(sess)
 	}
 
 }
 
+// setEnvironmentVariable replaces the container's variable with the same name or adds it
+func setEnvironmentVariable(definition *ecs.ContainerDefinition, pair *ecs.KeyValuePair) {
+	for i, existing := range definition.Environment {
+		if aws.StringValue(existing.Name) == aws.StringValue(pair.Name) {
+			definition.Environment[i] = pair
+			return
+		}
+	}
+	definition.Environment = append(definition.Environment, pair)
+}
+
+// setSecret replaces the container's secret with the same name or adds it
+func setSecret(definition *ecs.ContainerDefinition, secret *ecs.Secret) {
+	for i, existing := range definition.Secrets {
+		if aws.StringValue(existing.Name) == aws.StringValue(secret.Name) {
+			definition.Secrets[i] = secret
+			return
+		}
+	}
+	definition.Secrets = append(definition.Secrets, secret)
+}
+
+// setMountPoint replaces the container's mount point with the same path or adds it
+func setMountPoint(definition *ecs.ContainerDefinition, mountPoint *ecs.MountPoint) {
+	for i, existing := range definition.MountPoints {
+		if aws.StringValue(existing.ContainerPath) == aws.StringValue(mountPoint.ContainerPath) {
+			definition.MountPoints[i] = mountPoint
+			return
+		}
+	}
+	definition.MountPoints = append(definition.MountPoints, mountPoint)
+}
+
+// setPortMapping replaces the container's port mapping with the same
+// container port and protocol or adds it
+func setPortMapping(definition *ecs.ContainerDefinition, mapping *ecs.PortMapping) {
+	for i, existing := range definition.PortMappings {
+		if aws.Int64Value(existing.ContainerPort) == aws.Int64Value(mapping.ContainerPort) &&
+			strings.EqualFold(aws.StringValue(existing.Protocol), aws.StringValue(mapping.Protocol)) {
+			definition.PortMappings[i] = mapping
+			return
+		}
+	}
+	definition.PortMappings = append(definition.PortMappings, mapping)
+}
+
+// setUlimit replaces the container's ulimit with the same name or adds it
+func setUlimit(definition *ecs.ContainerDefinition, ulimit *ecs.Ulimit) {
+	for i, existing := range definition.Ulimits {
+		if aws.StringValue(existing.Name) == aws.StringValue(ulimit.Name) {
+			definition.Ulimits[i] = ulimit
+			return
+		}
+	}
+	definition.Ulimits = append(definition.Ulimits, ulimit)
+}
+
 func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error) {
 
 	var definition *ecs.ContainerDefinition
@@ -168,11 +359,21 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 	var oldTags []*ecs.Tag
 	var found = false
 
-	if len(p.ContainerName) == 0 {
-		p.ContainerName = p.Family + "-container"
+	if len(p.TaskDefinitionFile) != 0 {
+		template, err := p.loadTaskDefinitionFile()
+		if err != nil {
+			log.Println(err.Error())
+			return nil, err
+		}
+		oldTaskDefinition = taskDefinitionFromInput(template)
+		oldTags = template.Tags
+
+		if len(p.Family) == 0 {
+			p.Family = aws.StringValue(template.Family)
+		}
 	}
 
-	if len(p.ExistingTaskDefinitionArn) != 0 {
+	if oldTaskDefinition == nil && len(p.ExistingTaskDefinitionArn) != 0 {
 		inputTd := &ecs.DescribeTaskDefinitionInput{
 			TaskDefinition: aws.String(p.ExistingTaskDefinitionArn),
 			Include:        []*string{aws.String("TAGS")},
@@ -201,23 +402,37 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 
 		oldTaskDefinition = existingTdOutput.TaskDefinition
 		oldTags = existingTdOutput.Tags
+	}
 
+	if oldTaskDefinition != nil {
+		// The settings apply to a container of the definition, which is
+		// never replaced as a whole
+		if len(p.ContainerName) == 0 && len(oldTaskDefinition.ContainerDefinitions) == 1 {
+			p.ContainerName = aws.StringValue(oldTaskDefinition.ContainerDefinitions[0].Name)
+		}
+		names := []string{}
 		for _, container := range oldTaskDefinition.ContainerDefinitions {
-			if *container.Name == p.ContainerName {
+			names = append(names, aws.StringValue(container.Name))
+			if aws.StringValue(container.Name) == p.ContainerName {
 				definition = container
 				found = true
-				break
 			}
 		}
 		if !found {
-			log.Printf("Could not find container %s in task definition %s\n. Will register new task definition", p.ContainerName, p.ExistingTaskDefinitionArn)
+			err := fmt.Errorf(containerNotFoundErr+"%q in %s%s, set container_name to one of %s", p.ContainerName, p.ExistingTaskDefinitionArn, p.TaskDefinitionFile, strings.Join(names, ", "))
+			log.Println(err.Error())
+			return nil, err
 		}
+	}
 
-	} else {
-		definition.Name = aws.String(p.ContainerName)
+	if len(p.ContainerName) == 0 {
+		p.ContainerName = p.Family + "-container"
 	}
 
-	Image := p.DockerImage + ":" + p.Tag
+	if definition == nil {
+		// No task definition to start from, it is built from the settings
+		definition = p.newContainerDefinition()
+	}
 
 	// Fargate doesn't support privileged mode
 	if p.Compatibilities == "FARGATE" {
@@ -226,8 +441,11 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 
 	definition.Privileged = aws.Bool(p.Privileged)
 
-	if len(Image) > 1 {
-		definition.Image = aws.String(Image)
+	if len(p.DockerImage) != 0 {
+		definition.Image = aws.String(p.imageName())
+	} else if len(p.Tag) != 0 && definition.Image != nil {
+		// Only the tag changes, i.e. of an image from a task definition file
+		definition.Image = aws.String(imageWithTag(*definition.Image, p.Tag))
 	}
 
 	if p.CPU != 0 {
@@ -241,19 +459,23 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 		definition.MemoryReservation = aws.Int64(p.MemoryReservation)
 	}
 
+	settings, err := p.taskSettings()
+	if err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+
 	// Volumes
 
 	volumes := []*ecs.Volume{}
 
-	for _, volume := range p.Volumes {
-		cleanedVolume := strings.Trim(volume, " ")
-		parts := strings.SplitN(cleanedVolume, " ", 2)
+	for _, volume := range settings.Volumes {
 		vol := ecs.Volume{
-			Name: aws.String(parts[0]),
+			Name: aws.String(volume.Name),
 		}
-		if len(parts) == 2 {
+		if len(volume.SourcePath) != 0 {
 			vol.Host = &ecs.HostVolumeProperties{
-				SourcePath: aws.String(parts[1]),
+				SourcePath: aws.String(volume.SourcePath),
 			}
 		}
 
@@ -261,135 +483,91 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 	}
 
 	// EFS Volumes
-	for _, efsElem := range p.EfsVolumes {
-		cleanedEfs := strings.Trim(efsElem, " ")
-		parts := strings.SplitN(cleanedEfs, " ", 3)
+	for _, efsVolume := range settings.EfsVolumes {
 		vol := ecs.Volume{
-			Name: aws.String(parts[0]),
+			Name: aws.String(efsVolume.Name),
 		}
 		vol.EfsVolumeConfiguration = &ecs.EFSVolumeConfiguration{
-			FileSystemId:  aws.String(parts[1]),
-			RootDirectory: aws.String(parts[2]),
+			FileSystemId: aws.String(efsVolume.FileSystemID),
+		}
+		if len(efsVolume.RootDirectory) != 0 {
+			vol.EfsVolumeConfiguration.RootDirectory = aws.String(efsVolume.RootDirectory)
+		}
+		if efsVolume.TransitEncryption {
+			vol.EfsVolumeConfiguration.TransitEncryption = aws.String(ecs.EFSTransitEncryptionEnabled)
 		}
 
 		volumes = append(volumes, &vol)
 	}
 
 	// Mount Points
-	for _, mountPoint := range p.MountPoints {
-		cleanedMountPoint := strings.Trim(mountPoint, " ")
-		parts := strings.SplitN(cleanedMountPoint, " ", 3)
-
-		ro, readOnlyBoolParseErr := strconv.ParseBool(parts[2])
-		if readOnlyBoolParseErr != nil {
-			readOnlyBoolWrappedErr := errors.New(readOnlyBoolBaseParseErr + readOnlyBoolParseErr.Error())
-			log.Println(readOnlyBoolWrappedErr.Error())
-			return nil, readOnlyBoolWrappedErr
-		}
-
+	for _, mountPoint := range settings.MountPoints {
 		mpoint := ecs.MountPoint{
-			SourceVolume:  aws.String(parts[0]),
-			ContainerPath: aws.String(parts[1]),
-			ReadOnly:      aws.Bool(ro),
+			SourceVolume:  aws.String(mountPoint.SourceVolume),
+			ContainerPath: aws.String(mountPoint.ContainerPath),
+			ReadOnly:      aws.Bool(mountPoint.ReadOnly),
 		}
-		definition.MountPoints = append(definition.MountPoints, &mpoint)
+		setMountPoint(definition, &mpoint)
 	}
 
 	// Port mappings
-	for _, portMapping := range p.PortMappings {
-		cleanedPortMapping := strings.Trim(portMapping, " ")
-		parts := strings.SplitN(cleanedPortMapping, " ", 2)
-		hostPort, hostPortErr := strconv.ParseInt(parts[0], 10, 64)
-		if hostPortErr != nil {
-			hostPortWrappedErr := errors.New(hostPortBaseParseErr + hostPortErr.Error())
-			log.Println(hostPortWrappedErr.Error())
-			return nil, hostPortWrappedErr
-		}
-		containerPort, containerPortErr := strconv.ParseInt(parts[1], 10, 64)
-		if containerPortErr != nil {
-			containerPortWrappedErr := errors.New(containerBaseParseErr + containerPortErr.Error())
-			log.Println(containerPortWrappedErr.Error())
-			return nil, containerPortWrappedErr
-		}
-
+	for _, portMapping := range settings.PortMappings {
 		pair := ecs.PortMapping{
-			ContainerPort: aws.Int64(containerPort),
-			HostPort:      aws.Int64(hostPort),
-			Protocol:      aws.String("tcp"),
+			ContainerPort: aws.Int64(portMapping.ContainerPort),
+			HostPort:      aws.Int64(portMapping.HostPort),
+			Protocol:      aws.String(ecs.TransportProtocolTcp),
+		}
+		if len(portMapping.Protocol) != 0 {
+			pair.Protocol = aws.String(portMapping.Protocol)
 		}
 
-		definition.PortMappings = append(definition.PortMappings, &pair)
+		setPortMapping(definition, &pair)
 	}
 
 	// Environment variables
-	for _, envVar := range p.Environment {
-		parts := strings.SplitN(envVar, "=", 2)
+	for _, name := range sortedKeys(settings.Environment) {
 		pair := ecs.KeyValuePair{
-			Name:  aws.String(strings.Trim(parts[0], " ")),
-			Value: aws.String(strings.Trim(parts[1], " ")),
+			Name:  aws.String(name),
+			Value: aws.String(settings.Environment[name]),
 		}
-		definition.Environment = append(definition.Environment, &pair)
+		setEnvironmentVariable(definition, &pair)
 	}
 
 	// Secret Environment variables
-	for _, envVar := range p.SecretEnvironment {
-		parts := strings.SplitN(envVar, "=", 2)
-		pair := ecs.KeyValuePair{}
-		if len(parts) == 2 {
-			// set to custom named variable
-			pair.SetName(aws.StringValue(aws.String(strings.Trim(parts[0], " "))))
-			pair.SetValue(aws.StringValue(aws.String(os.Getenv(strings.Trim(parts[1], " ")))))
-		} else if len(parts) == 1 {
-			// default to named var
-			pair.SetName(aws.StringValue(aws.String(parts[0])))
-			pair.SetValue(aws.StringValue(aws.String(os.Getenv(parts[0]))))
-		} else {
-			log.Println("invalid syntax in secret enironment var", envVar)
+	for _, name := range sortedKeys(settings.SecretEnvironment) {
+		pair := ecs.KeyValuePair{
+			Name:  aws.String(name),
+			Value: aws.String(os.Getenv(settings.SecretEnvironment[name])),
 		}
-		definition.Environment = append(definition.Environment, &pair)
+		setEnvironmentVariable(definition, &pair)
 	}
 
 	// Environment variables from AWS Secrets manager
-	for _, envVar := range p.SecretsManagerEnvironment {
-		parts := strings.SplitN(envVar, "=", 2)
+	for _, name := range sortedKeys(settings.SecretsManager) {
 		pair := ecs.Secret{
-			Name:      aws.String(strings.Trim(parts[0], " ")),
-			ValueFrom: aws.String(strings.Trim(parts[1], " ")),
+			Name:      aws.String(name),
+			ValueFrom: aws.String(settings.SecretsManager[name]),
 		}
-		definition.Secrets = append(definition.Secrets, &pair)
+		setSecret(definition, &pair)
 	}
 
 	// Ulimits
-	for _, uLimit := range p.Ulimits {
-		cleanedULimit := strings.Trim(uLimit, " ")
-		parts := strings.SplitN(cleanedULimit, " ", 3)
-		name := strings.Trim(parts[0], " ")
-		softLimit, softLimitErr := strconv.ParseInt(parts[1], 10, 64)
-		if softLimitErr != nil {
-			softLimitWrappedErr := errors.New(softLimitBaseParseErr + softLimitErr.Error())
-			log.Println(softLimitWrappedErr.Error())
-			return nil, softLimitWrappedErr
-		}
-		hardLimit, hardLimitErr := strconv.ParseInt(parts[2], 10, 64)
-		if hardLimitErr != nil {
-			hardLimitWrappedErr := errors.New(hardLimitBaseParseErr + hardLimitErr.Error())
-			log.Println(hardLimitWrappedErr.Error())
-			return nil, hardLimitWrappedErr
-		}
-
+	for _, ulimit := range settings.Ulimits {
 		pair := ecs.Ulimit{
-			Name:      aws.String(name),
-			HardLimit: aws.Int64(hardLimit),
-			SoftLimit: aws.Int64(softLimit),
+			Name:      aws.String(ulimit.Name),
+			HardLimit: aws.Int64(ulimit.HardLimit),
+			SoftLimit: aws.Int64(ulimit.SoftLimit),
 		}
 
-		definition.Ulimits = append(definition.Ulimits, &pair)
+		setUlimit(definition, &pair)
 	}
 
 	// DockerLabels
-	for _, label := range p.Labels {
-		parts := strings.SplitN(label, "=", 2)
-		definition.DockerLabels[strings.Trim(parts[0], " ")] = aws.String(strings.Trim(parts[1], " "))
+	if len(settings.Labels) > 0 && definition.DockerLabels == nil {
+		definition.DockerLabels = make(map[string]*string)
+	}
+	for _, name := range sortedKeys(settings.Labels) {
+		definition.DockerLabels[name] = aws.String(settings.Labels[name])
 	}
 
 	// EntryPoint
@@ -405,25 +583,35 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 	if len(p.LogDriver) > 0 {
 		definition.LogConfiguration = new(ecs.LogConfiguration)
 		definition.LogConfiguration.LogDriver = &p.LogDriver
-		if len(p.LogOptions) > 0 {
+		if len(settings.LogOptions) > 0 {
 			definition.LogConfiguration.Options = make(map[string]*string)
-			for _, logOption := range p.LogOptions {
-				parts := strings.SplitN(logOption, "=", 2)
-				logOptionKey := strings.Trim(parts[0], " ")
-				logOptionValue := aws.String(strings.Trim(parts[1], " "))
-				definition.LogConfiguration.Options[logOptionKey] = logOptionValue
+			for _, name := range sortedKeys(settings.LogOptions) {
+				definition.LogConfiguration.Options[name] = aws.String(settings.LogOptions[name])
 			}
 		}
 	}
 
-	// Command
-	for _, v := range p.Command {
-		var command = v
-		definition.Command = append(definition.Command, &command)
+	// Command, replacing the one of an existing container
+	if len(p.Command) > 0 {
+		definition.Command = aws.StringSlice(p.Command)
 	}
 
 	if len(p.NetworkMode) == 0 {
-		p.NetworkMode = *oldTaskDefinition.NetworkMode
+		if oldTaskDefinition != nil {
+			p.NetworkMode = aws.StringValue(oldTaskDefinition.NetworkMode)
+		} else {
+			p.NetworkMode = p.defaultNetworkMode()
+		}
+	}
+
+	// DependsOn
+	if len(p.DependsOn) > 0 {
+		dependencies, err := p.parseDependsOn()
+		if err != nil {
+			log.Println(err.Error())
+			return nil, err
+		}
+		definition.DependsOn = dependencies
 	}
 
 	if len(p.HealthCheckCommand) != 0 {
@@ -441,26 +629,7 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 
 	var params *ecs.RegisterTaskDefinitionInput
 	if found {
-		params = &ecs.RegisterTaskDefinitionInput{
-			ContainerDefinitions:    oldTaskDefinition.ContainerDefinitions,
-			Cpu:                     oldTaskDefinition.Cpu,
-			EphemeralStorage:        oldTaskDefinition.EphemeralStorage,
-			ExecutionRoleArn:        oldTaskDefinition.ExecutionRoleArn,
-			Family:                  oldTaskDefinition.Family,
-			InferenceAccelerators:   oldTaskDefinition.InferenceAccelerators,
-			IpcMode:                 oldTaskDefinition.IpcMode,
-			Memory:                  oldTaskDefinition.Memory,
-			NetworkMode:             oldTaskDefinition.NetworkMode,
-			PidMode:                 oldTaskDefinition.PidMode,
-			PlacementConstraints:    oldTaskDefinition.PlacementConstraints,
-			ProxyConfiguration:      oldTaskDefinition.ProxyConfiguration,
-			RequiresCompatibilities: oldTaskDefinition.RequiresCompatibilities,
-			RuntimePlatform:         oldTaskDefinition.RuntimePlatform,
-			Tags:                    oldTags,
-			TaskRoleArn:             oldTaskDefinition.TaskRoleArn,
-			Volumes:                 oldTaskDefinition.Volumes,
-		}
-
+		params = registerInputFromTaskDefinition(oldTaskDefinition, oldTags)
 	} else {
 
 		params = &ecs.RegisterTaskDefinitionInput{
@@ -479,11 +648,11 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 		params.TaskRoleArn = aws.String(p.TaskRoleArn)
 	}
 
-	if p.NetworkMode != *params.NetworkMode {
+	if p.NetworkMode != aws.StringValue(params.NetworkMode) {
 		params.NetworkMode = aws.String(p.NetworkMode)
 	}
 
-	if p.Family != *params.Family {
+	if p.Family != aws.StringValue(params.Family) {
 		params.Family = aws.String(p.Family)
 	}
 
@@ -526,20 +695,102 @@ func (p *Plugin) createTaskDefinition() (*ecs.RegisterTaskDefinitionInput, error
 		params.ExecutionRoleArn = aws.String(p.TaskExecutionRoleArn)
 	}
 
+	if !found {
+		if err := p.checkNewTaskDefinition(params, definition); err != nil {
+			log.Println(err.Error())
+			return nil, err
+		}
+	}
+
+	if err := p.addContainers(params, definition); err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+
 	return params, nil
 }
 
 // Exec is main body of this plugin
 func (p *Plugin) Exec() error {
+	// Tasks started by this step are stopped when the step is cancelled
+	ctx, stopNotify := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stopNotify()
+
+	if len(p.Targets) != 0 {
+		return p.execTargets(ctx)
+	}
+	return p.execTarget(ctx)
+}
+
+// execTarget runs the task in the plugin's cluster and prunes the family's
+// revisions once it succeeded
+func (p *Plugin) execTarget(ctx context.Context) error {
+	err := p.execTask(ctx)
+	if err != nil || p.KeepRevisions <= 0 || len(p.taskDefinitionArn) == 0 {
+		return err
+	}
+
+	log.Printf("Pruning revisions, keeping the newest %d\n", p.KeepRevisions)
+	if err := p.pruneRevisions(taskDefinitionFamily(p.taskDefinitionArn), p.taskDefinitionArn, p.DryRun || p.PruneDryRun); err != nil {
+		// The task itself succeeded, so pruning doesn't fail the step
+		log.Println("Error pruning revisions: " + err.Error())
+	}
+	return nil
+}
+
+func (p *Plugin) execTask(ctx context.Context) error {
 	fmt.Println("Drone AWS ECS Plugin built")
 
-	p.Connect()
+	// Clients are injected in tests
+	if p.ecsService == nil {
+		p.Connect()
+	}
+
+	// like_service fills in the launch type and network settings, so it is
+	// read before they are validated
+	if len(p.LikeService) != 0 {
+		if err := p.applyLikeService(); err != nil {
+			log.Println(err.Error())
+			return err
+		}
+	}
+
+	// Settings are validated before the task definition is read
+	if err := p.validate(); err != nil {
+		log.Println(err.Error())
+		return err
+	}
+
+	placementConstraints, placementStrategy, err := p.setupPlacement()
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+
+	if err := p.resolveTaskDefinition(); err != nil {
+		log.Println(err.Error())
+		return err
+	}
 
 	var taskDefinition *string
+	var containerDefinitions []*ecs.ContainerDefinition
+	var registerParams *ecs.RegisterTaskDefinitionInput
 
 	// if p.ExistingTaskDefinitionArn != "" {
 	if p.UseExistingTaskDefinition && p.ExistingTaskDefinitionArn != "" {
 		taskDefinition = &p.ExistingTaskDefinitionArn
+		// the containers are needed to wait for the tasks, and to choose
+		// the container of the shards' variables
+		if (!p.DontWait && !p.DryRun) || p.shardCount() > 0 {
+			existingTdOutput, err := p.ecsService.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+				TaskDefinition: taskDefinition,
+			})
+			if err != nil {
+				log.Println("Could not describe task definition, logs won't be streamed and all containers' exit codes will be checked: " + err.Error())
+			} else {
+				containerDefinitions = existingTdOutput.TaskDefinition.ContainerDefinitions
+			}
+		}
 	} else {
 
 		params, err := p.createTaskDefinition()
@@ -547,29 +798,64 @@ func (p *Plugin) Exec() error {
 			log.Println("Error creating Task Definition")
 			return err
 		}
-		log.Println(params)
 
-		resp, err := p.ecsService.RegisterTaskDefinition(params)
-		if err != nil {
-			log.Println("Error registering Task Definition")
-			return err
+		if p.DryRun {
+			registerParams = params
+			taskDefinition = aws.String(dryRunTaskDefinition)
+		} else if existing := p.reuseTaskDefinition(params); existing != nil {
+			taskDefinition = existing.TaskDefinitionArn
+			containerDefinitions = existing.ContainerDefinitions
 		} else {
-			taskDefinition = resp.TaskDefinition.TaskDefinitionArn
+			log.Println(params)
+
+			resp, err := p.ecsService.RegisterTaskDefinition(params)
+			if err != nil {
+				log.Println("Error registering Task Definition")
+				return err
+			} else {
+				taskDefinition = resp.TaskDefinition.TaskDefinitionArn
+				containerDefinitions = resp.TaskDefinition.ContainerDefinitions
+			}
+		}
+
+		// Only the family of the revisions the plugin registers is pruned
+		p.taskDefinitionArn = aws.StringValue(taskDefinition)
+		if registerParams != nil {
+			// dry run, nothing registered yet
+			p.taskDefinitionArn = aws.StringValue(registerParams.Family)
 		}
 	}
 	// }
 
 	/// New section
 
+	if err := p.resolveShardContainer(containerDefinitions); err != nil {
+		log.Println(err.Error())
+		return err
+	}
+
+	overrides, err := p.setupTaskOverride()
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+
+	tags, err := p.setupTaskTags()
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+
 	taskParams := &ecs.RunTaskInput{
 		Cluster:              aws.String(p.Cluster),
 		Count:                aws.Int64(p.DesiredCount),
 		Group:                aws.String(p.Family),
 		LaunchType:           aws.String(p.Compatibilities),
 		NetworkConfiguration: p.setupServiceNetworkConfiguration(),
-		//Overrides:                TODO, or not - why override container if everything defined in this run (to consider)
-		//PlacementStrategy:        TODO
-		//Tags:                     TODO
+		Overrides:            overrides,
+		PlacementConstraints: placementConstraints,
+		PlacementStrategy:    placementStrategy,
+		Tags:                 tags,
 		TaskDefinition:       aws.String(*taskDefinition),
 		EnableExecuteCommand: aws.Bool(p.EnableExecuteCommand),
 	}
@@ -580,161 +866,210 @@ func (p *Plugin) Exec() error {
 	if p.PropagateTags {
 		taskParams.PropagateTags = aws.String("TASK_DEFINITION")
 	}
+	if startedBy := p.startedBy(); len(startedBy) != 0 {
+		taskParams.StartedBy = aws.String(startedBy)
+	}
+	if p.Idempotent {
+		taskParams.ReferenceId = aws.String(p.launchIdentity())
+		taskParams.ClientToken = aws.String(p.launchIdentity())
+	}
 
-	for _, capElem := range p.CapacityProviders {
-		cleanedCap := strings.Trim(capElem, " ")
-		parts := strings.SplitN(cleanedCap, " ", 3)
-		base, baseErr := strconv.ParseInt(parts[0], 10, 64)
-		weight, weightErr := strconv.ParseInt(parts[1], 10, 64)
-		if baseErr != nil {
-			baseWrapperErr := errors.New(capProviderBaseParseErr + baseErr.Error())
-			log.Println(baseWrapperErr.Error())
-			return baseWrapperErr
-		}
-		if weightErr != nil {
-			weightWrappedErr := errors.New(weightParseErr + weightErr.Error())
-			log.Println(weightWrappedErr.Error())
-			return weightWrappedErr
-		}
+	settings, err := p.taskSettings()
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+	if len(settings.CapacityProviders) > 0 {
+		// the launch type is given by the capacity providers
+		taskParams.LaunchType = nil
+	}
+	for _, capacityProvider := range settings.CapacityProviders {
 		cap := &ecs.CapacityProviderStrategyItem{
-			Base:             &base,
-			Weight:           &weight,
-			CapacityProvider: &parts[2],
+			Base:             aws.Int64(capacityProvider.Base),
+			Weight:           aws.Int64(capacityProvider.Weight),
+			CapacityProvider: aws.String(capacityProvider.Name),
 		}
 		taskParams.CapacityProviderStrategy = append(taskParams.CapacityProviderStrategy, cap)
 	}
+	if strategy := p.likeServiceCapacityProviders(settings); len(strategy) > 0 {
+		taskParams.LaunchType = nil
+		taskParams.CapacityProviderStrategy = strategy
+		if len(p.PlatformVersion) > 0 {
+			taskParams.PlatformVersion = &p.PlatformVersion
+		}
+	}
 
-	// Standalone task's placementConstraints seems to be redundant if we already use them in task definition... yes/no?
-	// this is bad: ecs.TaskDefinitionPlacementConstraint <> ecs.PlacementConstraint
-	/* if len(placementConstraints) > 0 {
-	    taskParams.PlacementConstraints = placementConstraints
-	} */
+	runInputs := []*ecs.RunTaskInput{taskParams}
+	if p.shardCount() > 0 {
+		runInputs, err = p.shardInputs(taskParams)
+		if err != nil {
+			log.Println(err.Error())
+			return err
+		}
+	}
 
-	tresp, terr := p.ecsService.RunTask(taskParams)
+	if p.DryRun {
+		log.Println("Dry run, nothing is registered nor started")
+		if registerParams != nil {
+			return p.printDryRun(registerParams, runInputs...)
+		}
+		return p.printDryRun(nil, runInputs...)
+	}
+
+	if ctx.Err() != nil {
+		// cancelled before anything was started
+		return errCancelled
+	}
+
+	if len(p.Singleton) != 0 {
+		if err := p.waitForSingleton(ctx); err != nil {
+			log.Println(err.Error())
+			return err
+		}
+	}
+
+	tasks, terr := p.startTasks(ctx, runInputs)
+	if terr == errCancelled {
+		return p.cancelTasks(taskArns(tasks))
+	}
 	if terr != nil {
+		log.Println(terr.Error())
+		if len(tasks) > 0 && p.TaskKillOnTimeout {
+			p.stopTasks(taskArns(tasks), "Drone's plugin could not start all tasks")
+		}
 		return terr
 	}
-	log.Println("Starting tasks:")
-	log.Println(tresp)
 
 	// get tasks' IDs:
-	tids := []*string{}
-	for _, task := range tresp.Tasks {
-		tid := task.TaskArn
-		tids = append(tids, tid)
+	tids := aws.StringSlice(taskArns(tasks))
+
+	tailer := newLogTailer(p.logsService, os.Stdout)
+	tailer.label = p.target
+	if p.StreamLogs && !p.DontWait {
+		tailer.addTasks(tasks, containerDefinitions)
+	}
+
+	finalOutput, err := p.waitForTasks(ctx, aws.StringValueSlice(tids), tailer)
+	if err != nil {
+		return err
 	}
 
-	describeTaskInput := &ecs.DescribeTasksInput{
-		Cluster: aws.String(p.Cluster),
-		Tasks:   tids,
+	if !p.DontWait {
+		finalOutput.Tasks, err = p.retryInfrastructureFailures(ctx, finalOutput.Tasks, runInputs, containerDefinitions, tailer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.DontWait {
+		// if ignore fail, print final finalOutput
+		if p.IgnoreExecutionFail {
+			log.Println(finalOutput)
+		}
+		return nil
 	}
 
+	results := taskResults(finalOutput.Tasks, p.decidingContainers(containerDefinitions))
+	p.setResultShards(results)
+	p.results = results
+	if len(p.target) == 0 {
+		// targets' results are printed together once all of them are done
+		printResultSummary(os.Stdout, results)
+	}
+
+	if p.IgnoreExecutionFail {
+		return nil
+	}
+	return p.checkResults(results)
+}
+
+// waitForTasks polls the tasks until all of them stopped, or are running
+// with DontWait, streaming their logs. The tasks are stopped on timeout and
+// when the step is cancelled. The polling interval grows while no task
+// changes status and when ECS throttles the calls
+func (p *Plugin) waitForTasks(ctx context.Context, tids []string, tailer *logTailer) (*ecs.DescribeTasksOutput, error) {
 	// Wait until each task is finished (or timeout)
-	//timeout := p.TaskTimeout
-	timeStart := time.Now().Unix()
-	timePassed := int64(0)
+	timeStart := time.Now()
+	deadline := timeStart.Add(time.Duration(p.TaskTimeout+1) * time.Second)
+	lastImpatienceLog := timeStart
+	interval := minPollInterval
 
 	taskStatus := make(map[string]string)
-	finalOutput := &ecs.DescribeTasksOutput{}
 	for {
 		allTasksStopped := true
-		tout, tterr := p.ecsService.DescribeTasks(describeTaskInput)
-		if tterr != nil {
-			return tterr
+		changed := false
+		tout, tterr := p.describeTasks(tids)
+		if tterr != nil && !request.IsErrorThrottle(tterr) {
+			return nil, tterr
 		}
-		// Get failures
-		if len(tout.Failures) > 0 {
-			log.Println("There are failures!")
-			log.Println(tout.Failures)
-			break
-		}
-
-		// Get tasks statuses
-		for _, task := range tout.Tasks {
-			val, exists := taskStatus[*task.TaskArn]
-			if (!exists) || (val != *task.LastStatus) {
-				taskStatus[*task.TaskArn] = *task.LastStatus
-				log.Println("Task: " + *task.TaskArn + "; status: " + *task.LastStatus)
+		if tterr != nil {
+			log.Println("DescribeTasks throttled, slowing down: " + tterr.Error())
+			interval = nextPollInterval(interval, true)
+		} else {
+			// Get failures
+			if len(tout.Failures) > 0 {
+				log.Println("There are failures!")
+				log.Println(tout.Failures)
+				return nil, fmt.Errorf(describeTasksFailedErr + formatFailures(tout.Failures))
 			}
-			if p.DontWait {
-				if len(val) == 0 || (val == "PROVISIONING") || (val == "PENDING") || (val == "ACTIVATING") {
-					allTasksStopped = false
+
+			// Get tasks statuses
+			for _, task := range tout.Tasks {
+				val, exists := taskStatus[*task.TaskArn]
+				if (!exists) || (val != *task.LastStatus) {
+					taskStatus[*task.TaskArn] = *task.LastStatus
+					log.Println("Task: " + *task.TaskArn + "; status: " + *task.LastStatus)
+					changed = true
 				}
-			} else {
-				if !(val == "STOPPED") {
-					allTasksStopped = false
+				if p.DontWait {
+					if len(val) == 0 || (val == "PROVISIONING") || (val == "PENDING") || (val == "ACTIVATING") {
+						allTasksStopped = false
+					}
+				} else {
+					if !(val == "STOPPED") {
+						allTasksStopped = false
+					}
 				}
 			}
+			interval = nextPollInterval(interval, !changed)
 		}
 
-		if timePassed != time.Now().Unix()-timeStart {
-			timePassed = time.Now().Unix() - timeStart
-			if timePassed > p.TaskTimeout {
-				log.Println("TIMEOUT!")
-				if p.TaskKillOnTimeout {
-					log.Println("Stopping tasks:")
-					// send kill signal to tasks
-					for key := range taskStatus {
-						stopTask := &ecs.StopTaskInput{
-							Cluster: aws.String(p.Cluster),
-							Reason:  aws.String(fmt.Sprintf("Drone's plugin timeout after %ds", timePassed)),
-							Task:    &key,
-						}
-						out, err := p.ecsService.StopTask(stopTask)
-						log.Println(out)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-				return fmt.Errorf(timeoutErr+"%ds", timePassed)
-			}
-			// Impatience log
-			if (timePassed)%10 == 0 {
-				log.Println("Still running...")
-			}
+		tailer.poll()
 
+		timePassed := int64(time.Since(timeStart) / time.Second)
+		if timePassed > p.TaskTimeout {
+			log.Println("TIMEOUT!")
+			tailer.flush()
+			if p.TaskKillOnTimeout {
+				// send kill signal to tasks
+				p.stopTasks(tids, fmt.Sprintf("Drone's plugin timeout after %ds", timePassed))
+			}
+			return nil, fmt.Errorf(timeoutErr+"%ds", timePassed)
+		}
+		// Impatience log
+		if time.Since(lastImpatienceLog) >= 10*time.Second {
+			lastImpatienceLog = time.Now()
+			log.Println("Still running...")
 		}
 
-		if allTasksStopped {
+		if tterr == nil && allTasksStopped {
 			if p.DontWait {
 				log.Println("All tasks running!")
 			} else {
 				log.Println("All tasks stopped!")
+				tailer.flush()
 			}
-			finalOutput = tout
-			break
+			return tout, nil
 		}
 
-		time.Sleep(200 * time.Millisecond)
-	}
-
-	// if ignore fail, print final finalOutput
-	if p.IgnoreExecutionFail {
-		log.Println(finalOutput)
-	} else if !p.DontWait {
-		// Check all containers exit codes
-		failedContainers := []string{}
-		for _, task := range finalOutput.Tasks {
-			for _, container := range task.Containers {
-				if container.ExitCode == nil {
-					log.Println("Task Failed")
-					log.Println(task)
-					return fmt.Errorf(taskFailedErr + *task.StoppedReason)
-				} else if *container.ExitCode != int64(0) {
-					log.Println(container.GoString())
-					failedContainers = append(failedContainers, container.GoString())
-				}
-			}
+		// don't sleep past the timeout
+		wait := interval
+		if untilDeadline := time.Until(deadline); untilDeadline < wait {
+			wait = untilDeadline
 		}
-
-		if len(failedContainers) > 0 {
-			//LogTime()
-			log.Println("Failed containers:")
-			log.Println(failedContainers)
-			return errors.New("there are failed containers")
+		if !sleep(ctx, wait) {
+			tailer.flush()
+			return nil, p.cancelTasks(tids)
 		}
 	}
-
-	return nil
 }
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// job is the fake ECS and the plugin of a test, built by newJob
+type job struct {
+	t    *testing.T
+	fake *fakeECS
+	p    *Plugin
+}
+
+// jobOption changes the fake ECS or the plugin of newJob
+type jobOption func(j *job)
+
+// newJob returns a fake ECS with the revision job:1, which has an `app`
+// container logging to awslogs and a non essential `sidecar`, and a plugin
+// registering a new revision of job:1 and running a single task of it. The
+// options are applied in order
+func newJob(t *testing.T, options ...jobOption) (*fakeECS, *Plugin) {
+	t.Helper()
+	fake := newFakeECS()
+	app := awslogsContainer("app")
+	app.Image = aws.String("example/app:1")
+	app.Environment = []*ecs.KeyValuePair{{Name: aws.String("MODE"), Value: aws.String("batch")}}
+	_, err := fake.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+		Family: aws.String("job"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			app,
+			{Name: aws.String("sidecar"), Image: aws.String("example/sidecar:1"), Essential: aws.Bool(false)},
+		},
+		Tags: []*ecs.Tag{{Key: aws.String("team"), Value: aws.String("data")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := &job{t: t, fake: fake, p: &Plugin{
+		Family:                    "job",
+		ContainerName:             "app",
+		DockerImage:               "example/app",
+		Tag:                       "2",
+		Cluster:                   "main",
+		DesiredCount:              1,
+		Compatibilities:           "EC2",
+		ExistingTaskDefinitionArn: "job",
+		TaskTimeout:               60,
+		ecsService:                fake,
+		logsService:               newFakeLogs(),
+	}}
+	for _, option := range options {
+		option(j)
+	}
+	return j.fake, j.p
+}
+
+// onFake runs the plugin against an existing fake ECS instead, like a step
+// run again. It comes before the options changing the fake
+func onFake(fake *fakeECS) jobOption {
+	return func(j *job) {
+		j.fake = fake
+		j.p.ecsService = fake
+	}
+}
+
+// withRevision registers a new revision of the family with an `app` container
+func withRevision(family string) jobOption {
+	return func(j *job) {
+		j.t.Helper()
+		_, err := j.fake.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+			Family:               aws.String(family),
+			ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("app"), Image: aws.String("example/app:1")}},
+		})
+		if err != nil {
+			j.t.Fatal(err)
+		}
+	}
+}
+
+// withService creates the service running job:1
+func withService(name string) jobOption {
+	return func(j *job) {
+		j.fake.addService(name, fakeArnPrefix+"task-definition/job:1")
+	}
+}
+
+// withAwsvpcService creates the service running job:1 on FARGATE_SPOT
+func withAwsvpcService(name string) jobOption {
+	return func(j *job) {
+		j.fake.addService(name, fakeArnPrefix+"task-definition/job:1")
+		service := j.fake.services[name]
+		service.NetworkConfiguration = &ecs.NetworkConfiguration{AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
+			Subnets:        aws.StringSlice([]string{"subnet-a", "subnet-b"}),
+			SecurityGroups: aws.StringSlice([]string{"sg-api"}),
+			AssignPublicIp: aws.String(ecs.AssignPublicIpDisabled),
+		}}
+		service.CapacityProviderStrategy = []*ecs.CapacityProviderStrategyItem{
+			{CapacityProvider: aws.String("FARGATE_SPOT"), Base: aws.Int64(0), Weight: aws.Int64(1)},
+		}
+		service.PlatformVersion = aws.String("1.4.0")
+	}
+}
+
+// likeService runs the `migrate` command in the latest revision of job,
+// configured like the service
+func likeService(name string) jobOption {
+	return func(j *job) {
+		j.p.ExistingTaskDefinitionArn = ""
+		j.p.Compatibilities = ""
+		j.p.UseExistingTaskDefinition = true
+		j.p.LikeService = name
+		j.p.OverrideCommand = []string{"migrate"}
+	}
+}
+
+// idempotent makes the plugin run the `migrate` step of org/app#42 idempotently
+func idempotent() jobOption {
+	return func(j *job) {
+		j.p.Idempotent = true
+		j.p.Build = Build{Repo: "org/app", Number: "42", Step: "migrate"}
+	}
+}
+
+func findContainer(definitions []*ecs.ContainerDefinition, name string) *ecs.ContainerDefinition {
+	for _, definition := range definitions {
+		if aws.StringValue(definition.Name) == name {
+			return definition
+		}
+	}
+	return nil
+}
+
+func environment(definition *ecs.ContainerDefinition) map[string]string {
+	env := map[string]string{}
+	for _, pair := range definition.Environment {
+		env[aws.StringValue(pair.Name)] = aws.StringValue(pair.Value)
+	}
+	return env
+}
+
+func TestCreateTaskDefinitionMergesIntoExisting(t *testing.T) {
+	_, p := newJob(t)
+	p.Environment = []string{"MODE=stream", "LEVEL=debug"}
+	p.Memory = 512
+
+	params, err := p.createTaskDefinition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := params.Validate(); err != nil {
+		t.Fatalf("expected a valid input, got %v", err)
+	}
+
+	app := findContainer(params.ContainerDefinitions, "app")
+	if app == nil {
+		t.Fatal("expected the app container to be kept")
+	}
+	if got := aws.StringValue(app.Image); got != "example/app:2" {
+		t.Errorf("expected image example/app:2, got %s", got)
+	}
+	if got := aws.Int64Value(app.Memory); got != 512 {
+		t.Errorf("expected memory 512, got %d", got)
+	}
+	env := environment(app)
+	if len(app.Environment) != 2 || env["MODE"] != "stream" || env["LEVEL"] != "debug" {
+		t.Errorf("expected MODE to be replaced and LEVEL added, got %v", app.Environment)
+	}
+	if app.LogConfiguration == nil || aws.StringValue(app.LogConfiguration.LogDriver) != awslogsDriver {
+		t.Errorf("expected the log configuration to be kept, got %v", app.LogConfiguration)
+	}
+
+	sidecar := findContainer(params.ContainerDefinitions, "sidecar")
+	if sidecar == nil || aws.StringValue(sidecar.Image) != "example/sidecar:1" {
+		t.Errorf("expected the sidecar to be kept unchanged, got %v", sidecar)
+	}
+	if len(params.Tags) != 1 || aws.StringValue(params.Tags[0].Key) != "team" {
+		t.Errorf("expected the tags to be kept, got %v", params.Tags)
+	}
+}
+
+func TestCreateTaskDefinitionFromScratch(t *testing.T) {
+	fake := newFakeECS()
+	p := &Plugin{
+		Family:      "report",
+		DockerImage: "example/report",
+		Memory:      256,
+		Environment: []string{"MODE=full"},
+		Labels:      []string{"team=data"},
+		LogDriver:   "awslogs",
+		LogOptions:  []string{"awslogs-group=/ecs/report"},
+		ecsService:  fake,
+	}
+
+	params, err := p.createTaskDefinition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := params.Validate(); err != nil {
+		t.Fatalf("expected a valid input, got %v", err)
+	}
+
+	if got := aws.StringValue(params.Family); got != "report" {
+		t.Errorf("expected family report, got %s", got)
+	}
+	if got := aws.StringValue(params.NetworkMode); got != ecs.NetworkModeBridge {
+		t.Errorf("expected bridge network mode, got %s", got)
+	}
+	if len(params.ContainerDefinitions) != 1 {
+		t.Fatalf("expected a single container, got %d", len(params.ContainerDefinitions))
+	}
+	container := params.ContainerDefinitions[0]
+	if got := aws.StringValue(container.Name); got != "report-container" {
+		t.Errorf("expected the container to be named after the family, got %s", got)
+	}
+	if got := aws.StringValue(container.Image); got != "example/report:latest" {
+		t.Errorf("expected the latest tag, got %s", got)
+	}
+	if !aws.BoolValue(container.Essential) {
+		t.Error("expected the container to be essential")
+	}
+	if env := environment(container); env["MODE"] != "full" {
+		t.Errorf("expected the environment to be set, got %v", container.Environment)
+	}
+	if got := aws.StringValue(container.DockerLabels["team"]); got != "data" {
+		t.Errorf("expected the labels to be set, got %v", container.DockerLabels)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("expected no ECS call, got %v", fake.calls)
+	}
+	if _, err := fake.RegisterTaskDefinition(params); err != nil {
+		t.Errorf("expected the task definition to be registered, got %v", err)
+	}
+}
+
+func TestCreateTaskDefinitionFromScratchForFargate(t *testing.T) {
+	p := &Plugin{
+		Family:          "report",
+		DockerImage:     "example/report",
+		Tag:             "1.0",
+		Compatibilities: "FARGATE",
+		TaskCPU:         "256",
+		TaskMemory:      "512",
+		ecsService:      newFakeECS(),
+	}
+
+	params, err := p.createTaskDefinition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := params.Validate(); err != nil {
+		t.Fatalf("expected a valid input, got %v", err)
+	}
+	if got := aws.StringValue(params.NetworkMode); got != ecs.NetworkModeAwsvpc {
+		t.Errorf("expected awsvpc network mode, got %s", got)
+	}
+	if got := aws.StringValue(params.ContainerDefinitions[0].Image); got != "example/report:1.0" {
+		t.Errorf("expected image example/report:1.0, got %s", got)
+	}
+}
+
+func TestCreateTaskDefinitionFromScratchReportsMissingSettings(t *testing.T) {
+	p := &Plugin{ecsService: newFakeECS()}
+
+	_, err := p.createTaskDefinition()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, setting := range []string{"family", "docker_image", "memory"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("expected %s to be reported, got %v", setting, err)
+		}
+	}
+}
+
+func TestImageName(t *testing.T) {
+	tests := []struct {
+		image, tag, expected string
+	}{
+		{"example/app", "", "example/app:latest"},
+		{"example/app", "2", "example/app:2"},
+		{"example/app:1", "", "example/app:1"},
+		{"example/app:1", "2", "example/app:2"},
+		{"registry:5000/app", "", "registry:5000/app:latest"},
+	}
+	for _, test := range tests {
+		p := &Plugin{DockerImage: test.image, Tag: test.tag}
+		if got := p.imageName(); got != test.expected {
+			t.Errorf("image %q tag %q: expected %s, got %s", test.image, test.tag, test.expected, got)
+		}
+	}
+}
+
+func TestCreateTaskDefinitionFailsOnMissingTaskDefinition(t *testing.T) {
+	_, p := newJob(t, onFake(newFakeECS()))
+	if _, err := p.createTaskDefinition(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestCreateTaskDefinitionFromFile(t *testing.T) {
+	t.Setenv("LOG_GROUP", "/ecs/report")
+	file := filepath.Join(t.TempDir(), "task-definition.yml")
+	content := `family: report
+networkMode: bridge
+containerDefinitions:
+  - name: report
+    image: example/report:latest
+    environment:
+      - name: MODE
+        value: full
+    logConfiguration:
+      logDriver: awslogs
+      options:
+        awslogs-group: ${LOG_GROUP}
+`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeECS()
+	p := &Plugin{
+		TaskDefinitionFile: file,
+		Tag:                "1.2.3",
+		Environment:        []string{"MODE=partial"},
+		ecsService:         fake,
+	}
+
+	params, err := p.createTaskDefinition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := aws.StringValue(params.Family); got != "report" {
+		t.Errorf("expected the family of the file, got %s", got)
+	}
+	report := findContainer(params.ContainerDefinitions, "report")
+	if report == nil {
+		t.Fatal("expected the report container")
+	}
+	if got := aws.StringValue(report.Image); got != "example/report:1.2.3" {
+		t.Errorf("expected the tag to be replaced, got %s", got)
+	}
+	if env := environment(report); len(report.Environment) != 1 || env["MODE"] != "partial" {
+		t.Errorf("expected MODE to be replaced, got %v", report.Environment)
+	}
+	if got := aws.StringValue(report.LogConfiguration.Options[awslogsGroup]); got != "/ecs/report" {
+		t.Errorf("expected the placeholder to be expanded, got %s", got)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("expected no ECS call, got %v", fake.calls)
+	}
+}
+
+func TestCreateTaskDefinitionFailsOnUnknownContainer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "task-definition.json")
+	content := `{"family": "web", "cpu": "512", "memory": "1024", "containerDefinitions": [
+		{"name": "app", "image": "example/app:1"},
+		{"name": "proxy", "image": "example/proxy:1"}
+	]}`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fake, _ := newJob(t)
+	tests := []struct {
+		name string
+		p    *Plugin
+	}{
+		{"file without container_name", &Plugin{TaskDefinitionFile: file, TaskMemory: "1024", ecsService: fake}},
+		{"existing definition with unknown container", &Plugin{ExistingTaskDefinitionArn: "job", ContainerName: "web", ecsService: fake}},
+	}
+	for _, test := range tests {
+		_, err := test.p.createTaskDefinition()
+		if err == nil || !strings.HasPrefix(err.Error(), containerNotFoundErr) {
+			t.Errorf("%s: expected a container not found error, got %v", test.name, err)
+		}
+	}
+	if _, err := tests[0].p.createTaskDefinition(); err == nil || !strings.Contains(err.Error(), "app, proxy") {
+		t.Errorf("expected the error to name the containers of the file, got %v", err)
+	}
+}
+
+func TestCreateTaskDefinitionUsesOnlyContainerOfExisting(t *testing.T) {
+	fake := newFakeECS()
+	_, err := fake.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+		Family:               aws.String("cron"),
+		Cpu:                  aws.String("512"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("worker"), Image: aws.String("example/worker:1")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Plugin{ExistingTaskDefinitionArn: "cron", Tag: "2", ecsService: fake}
+	params, err := p.createTaskDefinition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := findContainer(params.ContainerDefinitions, "worker")
+	if worker == nil || aws.StringValue(worker.Image) != "example/worker:2" {
+		t.Errorf("expected the worker container to be updated, got %v", params.ContainerDefinitions)
+	}
+	if aws.StringValue(params.Cpu) != "512" {
+		t.Errorf("expected the task cpu to be kept, got %v", params.Cpu)
+	}
+}
+
+func TestExecWaitsForTasksToStop(t *testing.T) {
+	fake, p := newJob(t)
+	p.StartedBy = "nightly"
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.runInputs) != 1 {
+		t.Fatalf("expected one RunTask call, got %d", len(fake.runInputs))
+	}
+	input := fake.runInputs[0]
+	if got := aws.StringValue(input.TaskDefinition); got != fakeArnPrefix+"task-definition/job:2" {
+		t.Errorf("expected the new revision to be run, got %s", got)
+	}
+	if got := aws.StringValue(input.StartedBy); got != "nightly" {
+		t.Errorf("expected startedBy nightly, got %s", got)
+	}
+	for arn, task := range fake.tasks {
+		if aws.StringValue(task.LastStatus) != ecs.DesiredStatusStopped {
+			t.Errorf("expected task %s to be stopped, got %s", arn, aws.StringValue(task.LastStatus))
+		}
+	}
+	if fake.calls["StopTask"] != 0 {
+		t.Errorf("expected no task to be stopped by the plugin, got %d StopTask calls", fake.calls["StopTask"])
+	}
+}
+
+func TestExecFailsOnNonZeroExitCode(t *testing.T) {
+	fake, p := newJob(t)
+	fake.exitCodes["app"] = 3
+	p.PropagateExitCode = true
+
+	err := p.Exec()
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an ExitError, got %v", err)
+	}
+	if exitErr.Code != 3 {
+		t.Errorf("expected exit code 3, got %d", exitErr.Code)
+	}
+}
+
+func TestExecIgnoresNonEssentialContainers(t *testing.T) {
+	fake, p := newJob(t)
+	fake.exitCodes["sidecar"] = 1
+
+	if err := p.Exec(); err != nil {
+		t.Fatalf("expected the sidecar's exit code to be ignored, got %v", err)
+	}
+}
+
+func TestExecStopsTasksOnTimeout(t *testing.T) {
+	fake, p := newJob(t)
+	fake.keepRunning = true
+	p.TaskTimeout = 0
+	p.TaskKillOnTimeout = true
+
+	err := p.Exec()
+	if err == nil || !strings.HasPrefix(err.Error(), timeoutErr) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if fake.calls["StopTask"] != 1 {
+		t.Errorf("expected the task to be stopped, got %d StopTask calls", fake.calls["StopTask"])
+	}
+}
+
+func TestExecTaskStopsTasksWhenCancelled(t *testing.T) {
+	fake, p := newJob(t)
+	fake.keepRunning = true
+	p.Build = Build{Repo: "org/app", Number: "42"}
+	p.CancelStopTimeout = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	if err := p.execTask(ctx); err != errCancelled {
+		t.Fatalf("expected the step to be cancelled, got %v", err)
+	}
+	if fake.calls["StopTask"] != 1 {
+		t.Fatalf("expected the task to be stopped, got %d StopTask calls", fake.calls["StopTask"])
+	}
+	for arn, task := range fake.tasks {
+		if got := aws.StringValue(task.StoppedReason); got != "Drone build org/app#42 cancelled" {
+			t.Errorf("expected task %s to be stopped naming the build, got %q", arn, got)
+		}
+		if aws.StringValue(task.LastStatus) != ecs.DesiredStatusStopped {
+			t.Errorf("expected task %s to be STOPPED, got %s", arn, aws.StringValue(task.LastStatus))
+		}
+	}
+}
+
+func TestExecFailsOnRunTaskFailure(t *testing.T) {
+	fake, p := newJob(t)
+	fake.runFailures = [][]*ecs.Failure{{{Reason: aws.String("MISSING")}}}
+
+	err := p.Exec()
+	if err == nil || !strings.HasPrefix(err.Error(), runTaskFailedErr) {
+		t.Fatalf("expected a RunTask error, got %v", err)
+	}
+	if fake.calls["RunTask"] != 1 {
+		t.Errorf("expected no retry, got %d RunTask calls", fake.calls["RunTask"])
+	}
+}
+
+func TestExecRetriesCapacityFailures(t *testing.T) {
+	fake, p := newJob(t)
+	fake.runFailures = [][]*ecs.Failure{{{Reason: aws.String("RESOURCE:MEMORY")}}}
+	p.RunTaskRetryTimeout = 10
+	p.RunTaskRetryBackoff = 1
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls["RunTask"] != 2 {
+		t.Errorf("expected RunTask to be retried once, got %d calls", fake.calls["RunTask"])
+	}
+}
+
+func TestExecReusesIdenticalRevision(t *testing.T) {
+	fake, p := newJob(t)
+	p.ReuseTaskDefinition = true
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, p = newJob(t, onFake(fake))
+	p.ReuseTaskDefinition = true
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.calls["RegisterTaskDefinition"] != 2 {
+		t.Errorf("expected job:2 to be reused, got %d registrations", fake.calls["RegisterTaskDefinition"])
+	}
+	if got := aws.StringValue(fake.runInputs[1].TaskDefinition); got != fakeArnPrefix+"task-definition/job:2" {
+		t.Errorf("expected job:2 to be run, got %s", got)
+	}
+}
+
+func TestExecDoesNotAccumulateListsOnLatestRevision(t *testing.T) {
+	fake, _ := newJob(t)
+	for run := 1; run <= 3; run++ {
+		_, p := newJob(t, onFake(fake))
+		p.ReuseTaskDefinition = true
+		p.Command = []string{"migrate"}
+		p.PortMappings = []string{"80 8080"}
+		p.Ulimits = []string{"nofile 1024 4096"}
+		if err := p.Exec(); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+
+	// job:1 of the fixture and job:2, reused by the later runs
+	if got := len(fake.taskDefinitions["job"]); got != 2 {
+		t.Errorf("expected a single new revision, got %d revisions", got)
+	}
+	app := findContainer(fake.taskDefinitions["job"][1].definition.ContainerDefinitions, "app")
+	if got := aws.StringValueSlice(app.Command); len(got) != 1 || got[0] != "migrate" {
+		t.Errorf("expected the command to be migrate, got %v", got)
+	}
+	if len(app.PortMappings) != 1 || len(app.Ulimits) != 1 {
+		t.Errorf("expected a single port mapping and ulimit, got %v and %v", app.PortMappings, app.Ulimits)
+	}
+}
+
+func TestExecRejectsOverrideEnvironmentBeforeRegistering(t *testing.T) {
+	fake, p := newJob(t)
+	p.OverrideEnvironment = []string{"LEVEL=debug", "VERBOSE"}
+
+	err := p.Exec()
+	if err == nil || !strings.Contains(err.Error(), `override_environment_variables[1] "VERBOSE": expected KEY=VALUE`) {
+		t.Fatalf("expected the variable to be named, got %v", err)
+	}
+	// job:1 was registered by the fixture
+	if fake.calls["RegisterTaskDefinition"] != 1 || fake.calls["RunTask"] != 0 {
+		t.Errorf("expected nothing to be registered nor run, got %v", fake.calls)
+	}
+}
+
+func TestExecDryRunSendsNothing(t *testing.T) {
+	fake, p := newJob(t)
+	p.DryRun = true
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	// job:1 was registered by the fixture
+	if fake.calls["RegisterTaskDefinition"] != 1 || fake.calls["RunTask"] != 0 {
+		t.Errorf("expected nothing to be registered nor run, got %v", fake.calls)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// The pruning below is the same in drone-ecs-standalone-task and
+// drone-ecs-task-update. The plugins are separate modules without a shared
+// package, so this file is copied on purpose: keep both copies in sync.
+
+// taskDefinitionFamily returns the family of a task definition ARN or
+// `family:revision`
+func taskDefinitionFamily(taskDefinition string) string {
+	return strings.SplitN(revisionName(taskDefinition), ":", 2)[0]
+}
+
+// revisionName returns the `family:revision` of a task definition ARN
+func revisionName(taskDefinition string) string {
+	if i := strings.LastIndex(taskDefinition, "/"); i != -1 {
+		return taskDefinition[i+1:]
+	}
+	return taskDefinition
+}
+
+// serviceTaskDefinitions returns the `family:revision` of the task definitions used by the services
+// of the cluster, including the ones of ongoing deployments
+func (p *Plugin) serviceTaskDefinitions() (map[string]bool, error) {
+	serviceArns := []*string{}
+	err := p.ecsService.ListServicesPages(&ecs.ListServicesInput{
+		Cluster: aws.String(p.Cluster),
+	}, func(page *ecs.ListServicesOutput, lastPage bool) bool {
+		serviceArns = append(serviceArns, page.ServiceArns...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	// DescribeServices takes at most 10 services
+	for start := 0; start < len(serviceArns); start += 10 {
+		end := start + 10
+		if end > len(serviceArns) {
+			end = len(serviceArns)
+		}
+		out, err := p.ecsService.DescribeServices(&ecs.DescribeServicesInput{
+			Cluster:  aws.String(p.Cluster),
+			Services: serviceArns[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range out.Services {
+			used[revisionName(aws.StringValue(service.TaskDefinition))] = true
+			for _, deployment := range service.Deployments {
+				used[revisionName(aws.StringValue(deployment.TaskDefinition))] = true
+			}
+		}
+	}
+	return used, nil
+}
+
+// pruneRevisions deregisters the ACTIVE revisions of the family beyond the
+// newest KeepRevisions. Revisions used by a service of the cluster and the
+// current one are kept. With dryRun the revisions are only listed
+func (p *Plugin) pruneRevisions(family string, current string, dryRun bool) error {
+	revisions := []string{}
+	err := p.ecsService.ListTaskDefinitionsPages(&ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: aws.String(family),
+		Status:       aws.String(ecs.TaskDefinitionStatusActive),
+		Sort:         aws.String(ecs.SortOrderDesc),
+	}, func(page *ecs.ListTaskDefinitionsOutput, lastPage bool) bool {
+		for _, arn := range page.TaskDefinitionArns {
+			// FamilyPrefix matches other families starting with the same name too
+			if taskDefinitionFamily(aws.StringValue(arn)) == family {
+				revisions = append(revisions, aws.StringValue(arn))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if int64(len(revisions)) <= p.KeepRevisions {
+		log.Printf("Family %s has %d ACTIVE revisions, nothing to prune\n", family, len(revisions))
+		return nil
+	}
+
+	used, err := p.serviceTaskDefinitions()
+	if err != nil {
+		return err
+	}
+
+	for _, arn := range revisions[p.KeepRevisions:] {
+		if used[revisionName(arn)] || revisionName(arn) == revisionName(current) {
+			log.Println("Keeping revision in use " + arn)
+			continue
+		}
+		if dryRun {
+			log.Println("Would deregister " + arn)
+			continue
+		}
+		log.Println("Deregistering " + arn)
+		if _, err := p.ecsService.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{
+			TaskDefinition: aws.String(arn),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func revisionNames(arns []string) string {
+	names := []string{}
+	for _, arn := range arns {
+		names = append(names, revisionName(arn))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestExecPrunesRevisionsKeepingServiceOnes(t *testing.T) {
+	// job:1 is used by the service api
+	fake, p := newJob(t, withRevision("job"), withRevision("job"), withService("api"))
+	p.KeepRevisions = 1
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got := revisionNames(fake.taskDefinitionRevisions("job", ecs.TaskDefinitionStatusActive)); got != "job:1,job:4" {
+		t.Errorf("expected the revision run and the one of the service to be kept, got %s", got)
+	}
+	if got := revisionNames(fake.taskDefinitionRevisions("job", ecs.TaskDefinitionStatusInactive)); got != "job:2,job:3" {
+		t.Errorf("expected job:2 and job:3 to be deregistered, got %s", got)
+	}
+}
+
+func TestExecPruneDryRunDeregistersNothing(t *testing.T) {
+	// job:1 is used by the service api
+	fake, p := newJob(t, withRevision("job"), withRevision("job"), withService("api"))
+	p.KeepRevisions = 1
+	p.PruneDryRun = true
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls["DeregisterTaskDefinition"] != 0 {
+		t.Errorf("expected no revision to be deregistered, got %d calls", fake.calls["DeregisterTaskDefinition"])
+	}
+	if got := revisionNames(fake.taskDefinitionRevisions("job", ecs.TaskDefinitionStatusActive)); got != "job:1,job:2,job:3,job:4" {
+		t.Errorf("expected every revision to stay ACTIVE, got %s", got)
+	}
+}
+
+func TestExecDoesNotPruneExistingTaskDefinition(t *testing.T) {
+	fake, p := newJob(t, withRevision("job"), withRevision("job"))
+	p.UseExistingTaskDefinition = true
+	p.KeepRevisions = 1
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got := revisionNames(fake.taskDefinitionRevisions("job", ecs.TaskDefinitionStatusActive)); got != "job:1,job:2,job:3" {
+		t.Errorf("expected nothing to be pruned without registering, got %s ACTIVE", got)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// resolveTaskDefinition sets ExistingTaskDefinitionArn to the revision the
+// task starts from: the task definition of TaskDefinitionFromService, or the
+// latest ACTIVE revision when only a family is given
+func (p *Plugin) resolveTaskDefinition() error {
+	if len(p.TaskDefinitionFromService) != 0 {
+		service, err := p.describeService(p.TaskDefinitionFromService)
+		if err != nil {
+			return errors.New(resolveTaskDefinitionErr + err.Error())
+		}
+		taskDefinition := aws.StringValue(service.TaskDefinition)
+		log.Printf("Using task definition %s of service %s\n", taskDefinition, p.TaskDefinitionFromService)
+		p.ExistingTaskDefinitionArn = taskDefinition
+		return nil
+	}
+
+	if len(p.ExistingTaskDefinitionArn) == 0 || strings.Contains(revisionName(p.ExistingTaskDefinitionArn), ":") {
+		// family:revision and ARNs are used as given
+		return nil
+	}
+
+	family := taskDefinitionFamily(p.ExistingTaskDefinitionArn)
+	taskDefinition, err := p.latestRevision(family)
+	if err != nil {
+		return errors.New(resolveTaskDefinitionErr + err.Error())
+	}
+	log.Printf("Using task definition %s, the latest revision of %s\n", taskDefinition, family)
+	p.ExistingTaskDefinitionArn = taskDefinition
+	return nil
+}
+
+// describeService returns the service of the cluster
+func (p *Plugin) describeService(name string) (*ecs.Service, error) {
+	out, err := p.ecsService.DescribeServices(&ecs.DescribeServicesInput{
+		Cluster:  aws.String(p.Cluster),
+		Services: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Failures) != 0 || len(out.Services) == 0 {
+		return nil, fmt.Errorf("service %s not found in cluster %s", name, p.Cluster)
+	}
+	return out.Services[0], nil
+}
+
+// latestRevision returns the ARN of the latest ACTIVE revision of the family
+func (p *Plugin) latestRevision(family string) (string, error) {
+	var latest string
+	err := p.ecsService.ListTaskDefinitionsPages(&ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: aws.String(family),
+		Status:       aws.String(ecs.TaskDefinitionStatusActive),
+		Sort:         aws.String(ecs.SortOrderDesc),
+	}, func(page *ecs.ListTaskDefinitionsOutput, lastPage bool) bool {
+		for _, arn := range page.TaskDefinitionArns {
+			// the prefix matches other families too
+			if taskDefinitionFamily(aws.StringValue(arn)) == family {
+				latest = aws.StringValue(arn)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(latest) == 0 {
+		return "", fmt.Errorf("no ACTIVE revision of task definition family %s", family)
+	}
+	return latest, nil
+}
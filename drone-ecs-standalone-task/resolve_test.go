@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestResolveTaskDefinitionFamilyToLatestActiveRevision(t *testing.T) {
+	// job-cleanup is a longer family sharing the prefix
+	fake, _ := newJob(t, withRevision("job"), withRevision("job"), withRevision("job-cleanup"))
+	if _, err := fake.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{TaskDefinition: aws.String("job:3")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, given := range []string{"job", fakeArnPrefix + "task-definition/job"} {
+		_, p := newJob(t, onFake(fake))
+		p.ExistingTaskDefinitionArn = given
+		if err := p.resolveTaskDefinition(); err != nil {
+			t.Fatal(err)
+		}
+		if expected := fakeArnPrefix + "task-definition/job:2"; p.ExistingTaskDefinitionArn != expected {
+			t.Errorf("expected %s to resolve to %s, got %s", given, expected, p.ExistingTaskDefinitionArn)
+		}
+	}
+}
+
+func TestResolveTaskDefinitionKeepsRevision(t *testing.T) {
+	fake, _ := newJob(t)
+	for _, given := range []string{"job:1", fakeArnPrefix + "task-definition/job:1"} {
+		_, p := newJob(t, onFake(fake))
+		p.ExistingTaskDefinitionArn = given
+		if err := p.resolveTaskDefinition(); err != nil {
+			t.Fatal(err)
+		}
+		if p.ExistingTaskDefinitionArn != given {
+			t.Errorf("expected %s to be kept, got %s", given, p.ExistingTaskDefinitionArn)
+		}
+	}
+	if fake.calls["ListTaskDefinitions"] != 0 {
+		t.Errorf("expected no lookup of a given revision, got %d", fake.calls["ListTaskDefinitions"])
+	}
+}
+
+func TestResolveTaskDefinitionFailsWithoutActiveRevision(t *testing.T) {
+	fake, p := newJob(t)
+	if _, err := fake.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{TaskDefinition: aws.String("job:1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.resolveTaskDefinition(); err == nil {
+		t.Error("expected an error without ACTIVE revision")
+	}
+}
+
+func TestExecRunsTaskDefinitionOfService(t *testing.T) {
+	fake, p := newJob(t, withRevision("job"), withService("api"))
+	p.ExistingTaskDefinitionArn = ""
+	p.TaskDefinitionFromService = "api"
+	p.UseExistingTaskDefinition = true
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := aws.StringValue(fake.runInputs[0].TaskDefinition); got != fakeArnPrefix+"task-definition/job:1" {
+		t.Errorf("expected the service's job:1 to be run, got %s", got)
+	}
+	if fake.calls["RegisterTaskDefinition"] != 2 {
+		t.Errorf("expected nothing to be registered, got %d registrations", fake.calls["RegisterTaskDefinition"])
+	}
+}
+
+func TestResolveTaskDefinitionFailsOnMissingService(t *testing.T) {
+	_, p := newJob(t)
+	p.ExistingTaskDefinitionArn = ""
+	p.TaskDefinitionFromService = "api"
+	if err := p.resolveTaskDefinition(); err == nil {
+		t.Error("expected an error for a missing service")
+	}
+}
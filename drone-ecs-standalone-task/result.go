@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// containerResult is the outcome of a single container of a stopped task
+type containerResult struct {
+	TaskArn   string
+	Container string
+	ExitCode  *int64
+	Reason    string
+	StartedAt *time.Time
+	StoppedAt *time.Time
+	// Deciding containers' exit codes decide whether the step succeeds
+	Deciding bool
+	// Shard is the SHARD_INDEX/SHARD_TOTAL of the task, empty when not sharded
+	Shard string
+}
+
+// ExitError makes the plugin exit with the exit code of a failed container
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// decidingContainers returns the containers whose exit codes decide whether
+// the step succeeds: the ones listed in the settings, by default the
+// essential ones. It returns nil, meaning all containers, when neither is known
+func (p *Plugin) decidingContainers(containerDefinitions []*ecs.ContainerDefinition) map[string]bool {
+	if len(p.DecidingContainers) > 0 {
+		deciding := map[string]bool{}
+		for _, name := range p.DecidingContainers {
+			deciding[strings.Trim(name, " ")] = true
+		}
+		return deciding
+	}
+	if containerDefinitions == nil {
+		return nil
+	}
+	return essentialContainers(containerDefinitions)
+}
+
+// taskResults returns a result per container of the tasks. deciding nil
+// means every container is deciding
+func taskResults(tasks []*ecs.Task, deciding map[string]bool) []containerResult {
+	results := []containerResult{}
+	for _, task := range tasks {
+		for _, container := range task.Containers {
+			reason := aws.StringValue(container.Reason)
+			if len(reason) == 0 && container.ExitCode == nil {
+				reason = aws.StringValue(task.StoppedReason)
+			}
+			results = append(results, containerResult{
+				TaskArn:   aws.StringValue(task.TaskArn),
+				Container: aws.StringValue(container.Name),
+				ExitCode:  container.ExitCode,
+				Reason:    reason,
+				StartedAt: task.StartedAt,
+				StoppedAt: task.StoppedAt,
+				Deciding:  deciding == nil || deciding[aws.StringValue(container.Name)],
+			})
+		}
+	}
+	return results
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// printResultSummary writes a table of the containers' results
+func printResultSummary(out io.Writer, results []containerResult) {
+	sharded := false
+	for _, result := range results {
+		if len(result.Shard) != 0 {
+			sharded = true
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if sharded {
+		fmt.Fprint(w, "SHARD\t")
+	}
+	fmt.Fprintln(w, "TASK\tCONTAINER\tEXIT CODE\tREASON\tSTARTED\tSTOPPED\tDURATION")
+	for _, result := range results {
+		exitCode := "-"
+		if result.ExitCode != nil {
+			exitCode = fmt.Sprintf("%d", *result.ExitCode)
+		}
+		container := result.Container
+		if !result.Deciding {
+			container = container + " (not deciding)"
+		}
+		reason := result.Reason
+		if len(reason) == 0 {
+			reason = "-"
+		}
+		duration := "-"
+		if result.StartedAt != nil && result.StoppedAt != nil {
+			duration = result.StoppedAt.Sub(*result.StartedAt).Round(time.Second).String()
+		}
+		if sharded {
+			fmt.Fprintf(w, "%s\t", result.Shard)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", result.TaskArn, container, exitCode, reason, formatTime(result.StartedAt), formatTime(result.StoppedAt), duration)
+	}
+	w.Flush()
+}
+
+// checkResults fails when a deciding container didn't run or exited with a
+// non zero exit code. With PropagateExitCode the error carries the exit
+// code of the first failed container, preferring the main container
+func (p *Plugin) checkResults(results []containerResult) error {
+	failed := []string{}
+	var exitResult *containerResult
+	decided := false
+	for i, result := range results {
+		if !result.Deciding {
+			continue
+		}
+		decided = true
+		if result.ExitCode == nil {
+			return fmt.Errorf(taskFailedErr+"container %s of task %s did not run: %s", result.Container, result.TaskArn, result.Reason)
+		}
+		if *result.ExitCode != 0 {
+			container := result.Container
+			if len(result.Shard) != 0 {
+				container = container + " of shard " + result.Shard
+			}
+			failed = append(failed, fmt.Sprintf("%s (exit code %d)", container, *result.ExitCode))
+			if exitResult == nil || (result.Container == p.ContainerName && exitResult.Container != p.ContainerName) {
+				exitResult = &results[i]
+			}
+		}
+	}
+
+	if !decided && len(results) > 0 {
+		return fmt.Errorf(taskFailedErr+"none of the deciding containers %v found in the tasks", p.DecidingContainers)
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("there are failed containers: %s", strings.Join(failed, ", "))
+	if p.PropagateExitCode {
+		return &ExitError{Code: int(*exitResult.ExitCode), Err: err}
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// infrastructureReasons are parts of stopped reasons of tasks and containers
+// which never really ran because of the infrastructure, as opposed to the
+// application failing
+var infrastructureReasons = []string{
+	"CannotPullContainerError",
+	"ResourceInitializationError",
+	"CannotCreateVolumeError",
+	"ContainerRuntimeError",
+	"ContainerRuntimeTimeoutError",
+	"DockerTimeoutError",
+	"InternalError",
+	"Spot Task was interrupted",
+	"Host EC2",
+	"Timeout waiting for network interface provisioning",
+}
+
+// infrastructureFailure returns why the task stopped for an infrastructure
+// reason, or an empty string when it stopped on its own
+func infrastructureFailure(task *ecs.Task) string {
+	stopCode := aws.StringValue(task.StopCode)
+	if stopCode == ecs.TaskStopCodeSpotInterruption || stopCode == ecs.TaskStopCodeTerminationNotice {
+		return stopCode + ": " + aws.StringValue(task.StoppedReason)
+	}
+
+	reasons := []string{aws.StringValue(task.StoppedReason)}
+	for _, container := range task.Containers {
+		reasons = append(reasons, aws.StringValue(container.Reason))
+	}
+	for _, reason := range reasons {
+		for _, infrastructureReason := range infrastructureReasons {
+			if strings.Contains(reason, infrastructureReason) {
+				return reason
+			}
+		}
+	}
+	return ""
+}
+
+// retryInput returns the input starting a single task again, the shard's
+// input for a shard
+func (p *Plugin) retryInput(task *ecs.Task, runInputs []*ecs.RunTaskInput) *ecs.RunTaskInput {
+	input := *runInputs[0]
+	if shard, ok := p.shards[aws.StringValue(task.TaskArn)]; ok {
+		input = *runInputs[shard]
+	}
+	input.Count = aws.Int64(1)
+	input.ClientToken = clientToken(input.ClientToken, "retry-"+aws.StringValue(task.TaskArn))
+	return &input
+}
+
+// retryInfrastructureFailures re-runs the stopped tasks which failed for an
+// infrastructure reason, at most InfrastructureRetries times with
+// exponential backoff. Tasks failing on their own are never re-run. It
+// returns the final tasks, the re-run ones replacing the failed ones
+func (p *Plugin) retryInfrastructureFailures(ctx context.Context, tasks []*ecs.Task, runInputs []*ecs.RunTaskInput, containerDefinitions []*ecs.ContainerDefinition, tailer *logTailer) ([]*ecs.Task, error) {
+	backoff := time.Duration(p.InfrastructureRetryBackoff) * time.Second
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for attempt := int64(1); attempt <= p.InfrastructureRetries; attempt++ {
+		kept := []*ecs.Task{}
+		failed := []*ecs.Task{}
+		for _, task := range tasks {
+			if reason := infrastructureFailure(task); len(reason) != 0 {
+				log.Printf("Task %s failed for an infrastructure reason: %s\n", aws.StringValue(task.TaskArn), reason)
+				failed = append(failed, task)
+			} else {
+				kept = append(kept, task)
+			}
+		}
+		if len(failed) == 0 {
+			return tasks, nil
+		}
+
+		log.Printf("Re-running %d tasks in %s (attempt %d of %d)\n", len(failed), backoff, attempt, p.InfrastructureRetries)
+		if !sleep(ctx, backoff) {
+			return tasks, errCancelled
+		}
+		backoff *= 2
+		if backoff > maxRunTaskRetryBackoff {
+			backoff = maxRunTaskRetryBackoff
+		}
+
+		started := []*ecs.Task{}
+		for _, task := range failed {
+			shard, sharded := p.shards[aws.StringValue(task.TaskArn)]
+			retried, err := p.runTasks(ctx, p.retryInput(task, runInputs))
+			for _, retriedTask := range retried {
+				if sharded {
+					p.shards[aws.StringValue(retriedTask.TaskArn)] = shard
+				}
+			}
+			started = append(started, retried...)
+			if err == errCancelled {
+				return tasks, p.cancelTasks(taskArns(started))
+			}
+			if err != nil {
+				log.Println(err.Error())
+				if len(started) > 0 && p.TaskKillOnTimeout {
+					p.stopTasks(taskArns(started), "Drone's plugin could not start all tasks")
+				}
+				return tasks, err
+			}
+		}
+
+		if p.StreamLogs {
+			tailer.addTasks(started, containerDefinitions)
+		}
+		out, err := p.waitForTasks(ctx, taskArns(started), tailer)
+		if err != nil {
+			return tasks, err
+		}
+		tasks = append(kept, out.Tasks...)
+	}
+
+	for _, task := range tasks {
+		if reason := infrastructureFailure(task); len(reason) != 0 {
+			log.Printf("Task %s failed for an infrastructure reason after %d retries: %s\n", aws.StringValue(task.TaskArn), p.InfrastructureRetries, reason)
+		}
+	}
+	return tasks, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestInfrastructureFailure(t *testing.T) {
+	tests := []struct {
+		name           string
+		task           *ecs.Task
+		infrastructure bool
+	}{
+		{
+			name:           "spot interruption",
+			task:           &ecs.Task{StopCode: aws.String(ecs.TaskStopCodeSpotInterruption), StoppedReason: aws.String("Your Spot Task was interrupted.")},
+			infrastructure: true,
+		},
+		{
+			name: "image pull",
+			task: &ecs.Task{
+				StoppedReason: aws.String("Task failed to start"),
+				Containers:    []*ecs.Container{{Reason: aws.String("CannotPullContainerError: pull access denied")}},
+			},
+			infrastructure: true,
+		},
+		{
+			name:           "application exit",
+			task:           &ecs.Task{StopCode: aws.String(ecs.TaskStopCodeEssentialContainerExited), StoppedReason: aws.String("Essential container in task exited")},
+			infrastructure: false,
+		},
+		{
+			name:           "stopped by the user",
+			task:           &ecs.Task{StopCode: aws.String(ecs.TaskStopCodeUserInitiated), StoppedReason: aws.String("Drone's plugin timeout after 61s")},
+			infrastructure: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := infrastructureFailure(test.task) != ""; got != test.infrastructure {
+				t.Errorf("expected infrastructure failure %t, got %t", test.infrastructure, got)
+			}
+		})
+	}
+}
+
+func TestExecRetriesInfrastructureFailures(t *testing.T) {
+	fake, p := newJob(t)
+	fake.pullFailures = 1
+	p.Shards = 2
+	p.InfrastructureRetries = 2
+	p.InfrastructureRetryBackoff = 1
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.runInputs) != 3 {
+		t.Fatalf("expected the failed shard to be run again, got %d RunTask calls", len(fake.runInputs))
+	}
+	// the first shard's task failed to pull its image
+	if env := overrideEnvironment(fake.runInputs[2].Overrides, "app"); env[shardIndexVariable] != "0" {
+		t.Errorf("expected shard 0 to be run again, got %v", env)
+	}
+	shards := map[string]bool{}
+	for _, result := range p.results {
+		shards[result.Shard] = true
+	}
+	if len(shards) != 2 {
+		t.Errorf("expected the final results of 2 shards, got %v", shards)
+	}
+}
+
+func TestExecFailsOnceInfrastructureRetriesAreExhausted(t *testing.T) {
+	fake, p := newJob(t)
+	fake.pullFailures = 2
+	p.InfrastructureRetries = 1
+	p.InfrastructureRetryBackoff = 1
+
+	err := p.Exec()
+	if err == nil || !strings.Contains(err.Error(), "did not run") {
+		t.Fatalf("expected the task not to run, got %v", err)
+	}
+	if len(fake.runInputs) != 2 {
+		t.Errorf("expected a single retry, got %d RunTask calls", len(fake.runInputs))
+	}
+}
+
+func TestExecDoesNotRetryApplicationFailures(t *testing.T) {
+	fake, p := newJob(t)
+	fake.exitCodes["app"] = 1
+	p.InfrastructureRetries = 3
+
+	if err := p.Exec(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(fake.runInputs) != 1 {
+		t.Errorf("expected no retry, got %d RunTask calls", len(fake.runInputs))
+	}
+}
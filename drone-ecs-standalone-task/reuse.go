@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// unorderedLists are the task definition lists whose order has no meaning
+var unorderedLists = map[string]bool{
+	"containerDefinitions":    true,
+	"dependsOn":               true,
+	"environment":             true,
+	"environmentFiles":        true,
+	"extraHosts":              true,
+	"mountPoints":             true,
+	"placementConstraints":    true,
+	"portMappings":            true,
+	"requiresCompatibilities": true,
+	"secrets":                 true,
+	"systemControls":          true,
+	"tags":                    true,
+	"ulimits":                 true,
+	"volumes":                 true,
+	"volumesFrom":             true,
+}
+
+// registerInputFromTaskDefinition returns the input which registers the
+// same task definition again
+func registerInputFromTaskDefinition(taskDefinition *ecs.TaskDefinition, tags []*ecs.Tag) *ecs.RegisterTaskDefinitionInput {
+	return &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions:    taskDefinition.ContainerDefinitions,
+		Cpu:                     taskDefinition.Cpu,
+		EphemeralStorage:        taskDefinition.EphemeralStorage,
+		ExecutionRoleArn:        taskDefinition.ExecutionRoleArn,
+		Family:                  taskDefinition.Family,
+		InferenceAccelerators:   taskDefinition.InferenceAccelerators,
+		IpcMode:                 taskDefinition.IpcMode,
+		Memory:                  taskDefinition.Memory,
+		NetworkMode:             taskDefinition.NetworkMode,
+		PidMode:                 taskDefinition.PidMode,
+		PlacementConstraints:    taskDefinition.PlacementConstraints,
+		ProxyConfiguration:      taskDefinition.ProxyConfiguration,
+		RequiresCompatibilities: taskDefinition.RequiresCompatibilities,
+		RuntimePlatform:         taskDefinition.RuntimePlatform,
+		Tags:                    tags,
+		TaskRoleArn:             taskDefinition.TaskRoleArn,
+		Volumes:                 taskDefinition.Volumes,
+	}
+}
+
+// normalize removes the values ECS treats as defaults (empty values, false,
+// zero) and sorts the unordered lists, so equal task definitions compare equal
+func normalize(value interface{}, key string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		normalized := map[string]interface{}{}
+		for k, child := range v {
+			if n := normalize(child, k); n != nil {
+				normalized[k] = n
+			}
+		}
+		if len(normalized) == 0 {
+			return nil
+		}
+		return normalized
+	case []interface{}:
+		normalized := []interface{}{}
+		for _, child := range v {
+			if n := normalize(child, ""); n != nil {
+				normalized = append(normalized, n)
+			}
+		}
+		if len(normalized) == 0 {
+			return nil
+		}
+		if unorderedLists[key] {
+			sort.Slice(normalized, func(i, j int) bool {
+				a, _ := json.Marshal(normalized[i])
+				b, _ := json.Marshal(normalized[j])
+				return string(a) < string(b)
+			})
+		}
+		return normalized
+	case bool:
+		if !v {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	case string:
+		if len(v) == 0 {
+			return nil
+		}
+	case nil:
+		return nil
+	}
+	return value
+}
+
+// applyDefaults sets the values ECS fills in when registering a task
+// definition, so an input without them matches the registered revision
+func applyDefaults(input *ecs.RegisterTaskDefinitionInput) *ecs.RegisterTaskDefinitionInput {
+	copied := *input
+	if copied.NetworkMode == nil {
+		copied.NetworkMode = aws.String(ecs.NetworkModeBridge)
+	}
+
+	containers := []*ecs.ContainerDefinition{}
+	for _, container := range input.ContainerDefinitions {
+		c := *container
+		if c.Essential == nil {
+			c.Essential = aws.Bool(true)
+		}
+		portMappings := []*ecs.PortMapping{}
+		for _, portMapping := range c.PortMappings {
+			pm := *portMapping
+			if pm.Protocol == nil {
+				pm.Protocol = aws.String(ecs.TransportProtocolTcp)
+			}
+			if pm.HostPort == nil && aws.StringValue(copied.NetworkMode) == ecs.NetworkModeAwsvpc {
+				pm.HostPort = pm.ContainerPort
+			}
+			portMappings = append(portMappings, &pm)
+		}
+		c.PortMappings = portMappings
+		containers = append(containers, &c)
+	}
+	copied.ContainerDefinitions = containers
+
+	return &copied
+}
+
+func normalizedTaskDefinition(input *ecs.RegisterTaskDefinitionInput) (interface{}, error) {
+	body, err := jsonutil.BuildJSON(applyDefaults(input))
+	if err != nil {
+		return nil, err
+	}
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	return normalize(document, ""), nil
+}
+
+// sameTaskDefinition tells whether both inputs register the same task definition
+func sameTaskDefinition(a *ecs.RegisterTaskDefinitionInput, b *ecs.RegisterTaskDefinitionInput) bool {
+	normalizedA, err := normalizedTaskDefinition(a)
+	if err != nil {
+		return false
+	}
+	normalizedB, err := normalizedTaskDefinition(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(normalizedA, normalizedB)
+}
+
+// reuseTaskDefinition returns the latest ACTIVE revision of the family if
+// reusing is enabled and it is identical to the task definition about to
+// be registered
+func (p *Plugin) reuseTaskDefinition(params *ecs.RegisterTaskDefinitionInput) *ecs.TaskDefinition {
+	if !p.ReuseTaskDefinition || params.Family == nil {
+		return nil
+	}
+
+	latest, err := p.ecsService.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: params.Family,
+		Include:        []*string{aws.String("TAGS")},
+	})
+	if err != nil {
+		log.Println("Could not describe the latest revision of " + *params.Family + ", registering a new one: " + err.Error())
+		return nil
+	}
+
+	if !sameTaskDefinition(params, registerInputFromTaskDefinition(latest.TaskDefinition, latest.Tags)) {
+		log.Println("Task definition differs from the latest revision " + aws.StringValue(latest.TaskDefinition.TaskDefinitionArn) + ", registering a new one")
+		return nil
+	}
+
+	log.Println("Task definition is identical to the latest revision, reusing " + aws.StringValue(latest.TaskDefinition.TaskDefinitionArn))
+	return latest.TaskDefinition
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	maxRunTaskRetryBackoff = 60 * time.Second
+
+	// RunTask starts at most 10 tasks per call, DescribeTasks describes at
+	// most 100 tasks per call
+	maxRunTaskCount      = 10
+	maxDescribeTaskCount = 100
+)
+
+var (
+	// The polling interval of the tasks' status starts at minPollInterval
+	// and grows up to maxPollInterval while nothing changes
+	minPollInterval = time.Second
+	maxPollInterval = 15 * time.Second
+)
+
+// nextPollInterval grows the interval by half when idle, otherwise resets it
+func nextPollInterval(interval time.Duration, idle bool) time.Duration {
+	if !idle {
+		return minPollInterval
+	}
+	interval = interval + interval/2
+	if interval > maxPollInterval {
+		interval = maxPollInterval
+	}
+	return interval
+}
+
+// retryableFailure tells whether a RunTask failure is caused by a temporary
+// lack of capacity, so running the task again later may succeed
+func retryableFailure(failure *ecs.Failure) bool {
+	reason := aws.StringValue(failure.Reason)
+	switch {
+	case strings.HasPrefix(reason, "RESOURCE:"):
+		return true
+	case reason == "AGENT":
+		return true
+	case strings.Contains(reason, "Capacity is unavailable"):
+		return true
+	}
+	return false
+}
+
+func formatFailures(failures []*ecs.Failure) string {
+	formatted := []string{}
+	for _, failure := range failures {
+		f := aws.StringValue(failure.Reason)
+		if detail := aws.StringValue(failure.Detail); len(detail) != 0 {
+			f = f + " (" + detail + ")"
+		}
+		if arn := aws.StringValue(failure.Arn); len(arn) != 0 {
+			f = arn + ": " + f
+		}
+		formatted = append(formatted, f)
+	}
+	return strings.Join(formatted, "; ")
+}
+
+// runTasks starts the tasks in batches of at most 10, retrying the ones ECS
+// could not place for lack of capacity with exponential backoff until the
+// retry timeout. The tasks started are returned even on error, so the caller
+// can stop them. Retrying ends early when ctx is cancelled
+func (p *Plugin) runTasks(ctx context.Context, input *ecs.RunTaskInput) ([]*ecs.Task, error) {
+	deadline := time.Now().Add(time.Duration(p.RunTaskRetryTimeout) * time.Second)
+	backoff := time.Duration(p.RunTaskRetryBackoff) * time.Second
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	remaining := aws.Int64Value(input.Count)
+	if remaining <= 0 {
+		remaining = 1
+	}
+
+	tasks := []*ecs.Task{}
+	for attempt, calls := 1, 0; ; calls++ {
+		batch := remaining
+		if batch > maxRunTaskCount {
+			batch = maxRunTaskCount
+		}
+		call := *input
+		call.Count = aws.Int64(batch)
+		call.ClientToken = clientToken(input.ClientToken, strconv.Itoa(calls))
+		out, err := p.ecsService.RunTask(&call)
+		if err != nil && p.BuildTags && taggingFailure(err) {
+			// The build tags are on by default, they must not prevent
+			// running the task in accounts which can't tag it
+			log.Println("Could not tag the tasks with the build metadata, starting them without it: " + err.Error())
+			untagged := *input
+			untagged.Tags = withoutBuildTags(input.Tags)
+			input = &untagged
+			call.Tags = input.Tags
+			out, err = p.ecsService.RunTask(&call)
+		}
+		if err != nil {
+			return tasks, err
+		}
+		log.Println("Starting tasks:")
+		log.Println(out)
+
+		tasks = append(tasks, out.Tasks...)
+		remaining -= int64(len(out.Tasks))
+		if remaining <= 0 {
+			return tasks, nil
+		}
+		if int64(len(out.Tasks)) == batch {
+			// the whole batch started, start the next one
+			continue
+		}
+
+		if len(out.Failures) == 0 {
+			return tasks, fmt.Errorf(runTaskFailedErr+"%d tasks not started, no failure reported", remaining)
+		}
+		log.Printf("Could not start %d tasks: %s\n", remaining, formatFailures(out.Failures))
+
+		for _, failure := range out.Failures {
+			if !retryableFailure(failure) {
+				return tasks, fmt.Errorf(runTaskFailedErr + formatFailures(out.Failures))
+			}
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return tasks, fmt.Errorf(runTaskFailedErr+"%d tasks never started after %d attempts: %s", remaining, attempt, formatFailures(out.Failures))
+		}
+
+		attempt++
+		log.Printf("Retrying in %s (attempt %d)\n", backoff, attempt)
+		if !sleep(ctx, backoff) {
+			return tasks, errCancelled
+		}
+		backoff *= 2
+		if backoff > maxRunTaskRetryBackoff {
+			backoff = maxRunTaskRetryBackoff
+		}
+	}
+}
+
+// stopTasks sends the stop signal to the tasks
+func (p *Plugin) stopTasks(taskArns []string, reason string) {
+	log.Println("Stopping tasks:")
+	for _, taskArn := range taskArns {
+		stopTask := &ecs.StopTaskInput{
+			Cluster: aws.String(p.Cluster),
+			Reason:  aws.String(reason),
+			Task:    aws.String(taskArn),
+		}
+		out, err := p.ecsService.StopTask(stopTask)
+		log.Println(out)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func taskArns(tasks []*ecs.Task) []string {
+	arns := []string{}
+	for _, task := range tasks {
+		arns = append(arns, aws.StringValue(task.TaskArn))
+	}
+	return arns
+}
+
+// describeTasks describes the tasks in pages of at most 100 tasks
+func (p *Plugin) describeTasks(arns []string) (*ecs.DescribeTasksOutput, error) {
+	out := &ecs.DescribeTasksOutput{}
+	for start := 0; start < len(arns); start += maxDescribeTaskCount {
+		end := start + maxDescribeTaskCount
+		if end > len(arns) {
+			end = len(arns)
+		}
+		page, err := p.ecsService.DescribeTasks(&ecs.DescribeTasksInput{
+			Cluster: aws.String(p.Cluster),
+			Tasks:   aws.StringSlice(arns[start:end]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		out.Tasks = append(out.Tasks, page.Tasks...)
+		out.Failures = append(out.Failures, page.Failures...)
+	}
+	return out, nil
+}
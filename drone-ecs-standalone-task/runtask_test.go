@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// the fake ECS moves the tasks forward at every call, no need to wait
+	minPollInterval = 10 * time.Millisecond
+	maxPollInterval = 50 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+func TestNextPollInterval(t *testing.T) {
+	interval := minPollInterval
+	for i := 0; i < 20; i++ {
+		interval = nextPollInterval(interval, true)
+	}
+	if interval != maxPollInterval {
+		t.Errorf("expected the interval to grow up to %s, got %s", maxPollInterval, interval)
+	}
+	if interval = nextPollInterval(interval, false); interval != minPollInterval {
+		t.Errorf("expected the interval to be reset to %s, got %s", minPollInterval, interval)
+	}
+}
+
+func TestExecStartsTasksInBatches(t *testing.T) {
+	fake, p := newJob(t)
+	p.DesiredCount = 125
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.calls["RunTask"] != 13 {
+		t.Errorf("expected 13 RunTask calls, got %d", fake.calls["RunTask"])
+	}
+	if len(fake.tasks) != 125 {
+		t.Errorf("expected 125 tasks, got %d", len(fake.tasks))
+	}
+	if len(p.results) != 250 {
+		t.Errorf("expected the results of the 2 containers of every task, got %d", len(p.results))
+	}
+}
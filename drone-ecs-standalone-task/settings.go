@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"gopkg.in/yaml.v3"
+)
+
+type PortMappingSetting struct {
+	HostPort      int64  `yaml:"host_port"`
+	ContainerPort int64  `yaml:"container_port"`
+	Protocol      string `yaml:"protocol"`
+}
+
+type UlimitSetting struct {
+	Name      string `yaml:"name"`
+	SoftLimit int64  `yaml:"soft_limit"`
+	HardLimit int64  `yaml:"hard_limit"`
+}
+
+type VolumeSetting struct {
+	Name       string `yaml:"name"`
+	SourcePath string `yaml:"source_path"`
+}
+
+type EfsVolumeSetting struct {
+	Name              string `yaml:"name"`
+	FileSystemID      string `yaml:"file_system_id"`
+	RootDirectory     string `yaml:"root_directory"`
+	TransitEncryption bool   `yaml:"transit_encryption"`
+}
+
+type MountPointSetting struct {
+	SourceVolume  string `yaml:"source_volume"`
+	ContainerPath string `yaml:"container_path"`
+	ReadOnly      bool   `yaml:"read_only"`
+}
+
+type CapacityProviderSetting struct {
+	Base   int64  `yaml:"base"`
+	Weight int64  `yaml:"weight"`
+	Name   string `yaml:"name"`
+}
+
+// TaskSettings is the structured alternative to the space and `=` delimited
+// settings. Both are merged, the structured entries coming last
+type TaskSettings struct {
+	PortMappings      []PortMappingSetting      `yaml:"port_mappings"`
+	Ulimits           []UlimitSetting           `yaml:"ulimits"`
+	Volumes           []VolumeSetting           `yaml:"volumes"`
+	EfsVolumes        []EfsVolumeSetting        `yaml:"efs_volumes"`
+	MountPoints       []MountPointSetting       `yaml:"mount_points"`
+	CapacityProviders []CapacityProviderSetting `yaml:"capacity_providers"`
+	Environment       map[string]string         `yaml:"environment_variables"`
+	// SecretEnvironment maps the container variable to the drone secret variable
+	SecretEnvironment map[string]string `yaml:"secret_environment_variables"`
+	SecretsManager    map[string]string `yaml:"secrets_manager_variables"`
+	Labels            map[string]string `yaml:"labels"`
+	LogOptions        map[string]string `yaml:"log_options"`
+}
+
+// settingsErrors collects every invalid entry, so they are reported at once
+type settingsErrors []string
+
+func (e *settingsErrors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+func (e settingsErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return errors.New(settingsErr + strings.Join(e, "; "))
+}
+
+// sortedKeys returns the keys of the map in order, so the task definition
+// is the same from run to run
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *TaskSettings) validate(errs *settingsErrors) {
+	for i, pm := range s.PortMappings {
+		if pm.ContainerPort < 1 || pm.ContainerPort > 65535 {
+			errs.add("port_mappings[%d]: container_port must be between 1 and 65535, got %d", i, pm.ContainerPort)
+		}
+		if pm.HostPort < 0 || pm.HostPort > 65535 {
+			errs.add("port_mappings[%d]: host_port must be between 0 and 65535, got %d", i, pm.HostPort)
+		}
+		if len(pm.Protocol) != 0 && !contains(ecs.TransportProtocol_Values(), pm.Protocol) {
+			errs.add("port_mappings[%d]: protocol must be one of %v, got %q", i, ecs.TransportProtocol_Values(), pm.Protocol)
+		}
+	}
+
+	for i, ulimit := range s.Ulimits {
+		if !contains(ecs.UlimitName_Values(), ulimit.Name) {
+			errs.add("ulimits[%d]: name must be one of %v, got %q", i, ecs.UlimitName_Values(), ulimit.Name)
+		}
+		if ulimit.SoftLimit > ulimit.HardLimit {
+			errs.add("ulimits[%d] %s: soft_limit %d is greater than hard_limit %d", i, ulimit.Name, ulimit.SoftLimit, ulimit.HardLimit)
+		}
+	}
+
+	for i, volume := range s.Volumes {
+		if len(volume.Name) == 0 {
+			errs.add("volumes[%d]: name is required", i)
+		}
+	}
+
+	for i, volume := range s.EfsVolumes {
+		if len(volume.Name) == 0 {
+			errs.add("efs_volumes[%d]: name is required", i)
+		}
+		if len(volume.FileSystemID) == 0 {
+			errs.add("efs_volumes[%d] %s: file_system_id is required", i, volume.Name)
+		}
+	}
+
+	for i, mountPoint := range s.MountPoints {
+		if len(mountPoint.SourceVolume) == 0 {
+			errs.add("mount_points[%d]: source_volume is required", i)
+		}
+		if len(mountPoint.ContainerPath) == 0 {
+			errs.add("mount_points[%d] %s: container_path is required", i, mountPoint.SourceVolume)
+		}
+	}
+
+	for i, capacityProvider := range s.CapacityProviders {
+		if len(capacityProvider.Name) == 0 {
+			errs.add("capacity_providers[%d]: name is required", i)
+		}
+		if capacityProvider.Base < 0 || capacityProvider.Base > 100000 {
+			errs.add("capacity_providers[%d] %s: base must be between 0 and 100000, got %d", i, capacityProvider.Name, capacityProvider.Base)
+		}
+		if capacityProvider.Weight < 0 || capacityProvider.Weight > 1000 {
+			errs.add("capacity_providers[%d] %s: weight must be between 0 and 1000, got %d", i, capacityProvider.Name, capacityProvider.Weight)
+		}
+	}
+
+	maps := []struct {
+		name   string
+		values map[string]string
+	}{
+		{"environment_variables", s.Environment},
+		{"secret_environment_variables", s.SecretEnvironment},
+		{"secrets_manager_variables", s.SecretsManager},
+		{"labels", s.Labels},
+		{"log_options", s.LogOptions},
+	}
+	for _, m := range maps {
+		for key := range m.values {
+			if len(strings.TrimSpace(key)) == 0 {
+				errs.add("%s: empty name", m.name)
+			}
+		}
+	}
+}
+
+// splitKeyValue splits a `KEY=VALUE` setting, trimming both parts
+func splitKeyValue(setting string, index int, value string, errs *settingsErrors) (string, string, bool) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		errs.add("%s[%d] %q: expected KEY=VALUE", setting, index, value)
+		return "", "", false
+	}
+	return strings.Trim(parts[0], " "), strings.Trim(parts[1], " "), true
+}
+
+func parseInt(setting string, index int, value string, baseErr string, errs *settingsErrors) int64 {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		errs.add("%s[%d]: %s%s", setting, index, baseErr, err.Error())
+	}
+	return i
+}
+
+// legacySettings parses the space and `=` delimited settings
+func (p *Plugin) legacySettings() (*TaskSettings, settingsErrors) {
+	s := &TaskSettings{
+		Environment:       map[string]string{},
+		SecretEnvironment: map[string]string{},
+		SecretsManager:    map[string]string{},
+		Labels:            map[string]string{},
+		LogOptions:        map[string]string{},
+	}
+	errs := settingsErrors{}
+
+	for i, portMapping := range p.PortMappings {
+		parts := strings.Fields(portMapping)
+		if len(parts) != 2 {
+			errs.add("port_mappings[%d] %q: expected `hostPort containerPort`", i, portMapping)
+			continue
+		}
+		s.PortMappings = append(s.PortMappings, PortMappingSetting{
+			HostPort:      parseInt("port_mappings", i, parts[0], hostPortBaseParseErr, &errs),
+			ContainerPort: parseInt("port_mappings", i, parts[1], containerBaseParseErr, &errs),
+			Protocol:      ecs.TransportProtocolTcp,
+		})
+	}
+
+	for i, ulimit := range p.Ulimits {
+		parts := strings.Fields(ulimit)
+		if len(parts) != 3 {
+			errs.add("ulimits[%d] %q: expected `name softLimit hardLimit`", i, ulimit)
+			continue
+		}
+		s.Ulimits = append(s.Ulimits, UlimitSetting{
+			Name:      parts[0],
+			SoftLimit: parseInt("ulimits", i, parts[1], softLimitBaseParseErr, &errs),
+			HardLimit: parseInt("ulimits", i, parts[2], hardLimitBaseParseErr, &errs),
+		})
+	}
+
+	for _, volume := range p.Volumes {
+		parts := strings.SplitN(strings.Trim(volume, " "), " ", 2)
+		v := VolumeSetting{Name: parts[0]}
+		if len(parts) == 2 {
+			v.SourcePath = parts[1]
+		}
+		s.Volumes = append(s.Volumes, v)
+	}
+
+	for i, efsVolume := range p.EfsVolumes {
+		parts := strings.Fields(efsVolume)
+		if len(parts) != 3 {
+			errs.add("efs_volumes[%d] %q: expected `name efs-id root-directory`", i, efsVolume)
+			continue
+		}
+		s.EfsVolumes = append(s.EfsVolumes, EfsVolumeSetting{
+			Name:          parts[0],
+			FileSystemID:  parts[1],
+			RootDirectory: parts[2],
+		})
+	}
+
+	for i, mountPoint := range p.MountPoints {
+		parts := strings.Fields(mountPoint)
+		if len(parts) != 3 {
+			errs.add("mount_points[%d] %q: expected `sourceVolume containerPath readOnly`", i, mountPoint)
+			continue
+		}
+		ro, err := strconv.ParseBool(parts[2])
+		if err != nil {
+			errs.add("mount_points[%d]: %s%s", i, readOnlyBoolBaseParseErr, err.Error())
+		}
+		s.MountPoints = append(s.MountPoints, MountPointSetting{
+			SourceVolume:  parts[0],
+			ContainerPath: parts[1],
+			ReadOnly:      ro,
+		})
+	}
+
+	for i, capacityProvider := range p.CapacityProviders {
+		parts := strings.Fields(capacityProvider)
+		if len(parts) != 3 {
+			errs.add("capacity_providers[%d] %q: expected `base weight name`", i, capacityProvider)
+			continue
+		}
+		s.CapacityProviders = append(s.CapacityProviders, CapacityProviderSetting{
+			Base:   parseInt("capacity_providers", i, parts[0], capProviderBaseParseErr, &errs),
+			Weight: parseInt("capacity_providers", i, parts[1], weightParseErr, &errs),
+			Name:   parts[2],
+		})
+	}
+
+	for i, envVar := range p.Environment {
+		if name, value, ok := splitKeyValue("environment_variables", i, envVar, &errs); ok {
+			s.Environment[name] = value
+		}
+	}
+
+	for _, envVar := range p.SecretEnvironment {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) == 2 {
+			// set to custom named variable
+			s.SecretEnvironment[strings.Trim(parts[0], " ")] = strings.Trim(parts[1], " ")
+		} else {
+			// default to named var
+			s.SecretEnvironment[parts[0]] = parts[0]
+		}
+	}
+
+	for i, envVar := range p.SecretsManagerEnvironment {
+		if name, value, ok := splitKeyValue("secrets_manager_variables", i, envVar, &errs); ok {
+			s.SecretsManager[name] = value
+		}
+	}
+
+	for i, label := range p.Labels {
+		if name, value, ok := splitKeyValue("labels", i, label, &errs); ok {
+			s.Labels[name] = value
+		}
+	}
+
+	for i, logOption := range p.LogOptions {
+		if name, value, ok := splitKeyValue("log_options", i, logOption, &errs); ok {
+			s.LogOptions[name] = value
+		}
+	}
+
+	return s, errs
+}
+
+// parseSettingsDocument decodes a yaml or json settings document, rejecting
+// unknown fields
+func parseSettingsDocument(document string) (*TaskSettings, error) {
+	s := &TaskSettings{}
+	decoder := yaml.NewDecoder(bytes.NewBufferString(document))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(s); err != nil {
+		return nil, errors.New(settingsErr + err.Error())
+	}
+	return s, nil
+}
+
+// merge appends the lists of other and overrides the maps' values with its ones
+func (s *TaskSettings) merge(other *TaskSettings) {
+	s.PortMappings = append(s.PortMappings, other.PortMappings...)
+	s.Ulimits = append(s.Ulimits, other.Ulimits...)
+	s.Volumes = append(s.Volumes, other.Volumes...)
+	s.EfsVolumes = append(s.EfsVolumes, other.EfsVolumes...)
+	s.MountPoints = append(s.MountPoints, other.MountPoints...)
+	s.CapacityProviders = append(s.CapacityProviders, other.CapacityProviders...)
+
+	maps := []struct{ to, from map[string]string }{
+		{s.Environment, other.Environment},
+		{s.SecretEnvironment, other.SecretEnvironment},
+		{s.SecretsManager, other.SecretsManager},
+		{s.Labels, other.Labels},
+		{s.LogOptions, other.LogOptions},
+	}
+	for _, m := range maps {
+		for key, value := range m.from {
+			m.to[key] = value
+		}
+	}
+}
+
+// taskSettings returns the legacy settings merged with the settings
+// document and file, validated. The result is parsed once
+func (p *Plugin) taskSettings() (*TaskSettings, error) {
+	if p.settings != nil {
+		return p.settings, nil
+	}
+
+	s, errs := p.legacySettings()
+
+	documents := []string{}
+	if len(p.SettingsFile) != 0 {
+		content, err := os.ReadFile(p.SettingsFile)
+		if err != nil {
+			return nil, errors.New(settingsErr + err.Error())
+		}
+		documents = append(documents, string(content))
+	}
+	if len(strings.TrimSpace(p.Settings)) != 0 {
+		documents = append(documents, p.Settings)
+	}
+	for _, document := range documents {
+		parsed, err := parseSettingsDocument(document)
+		if err != nil {
+			return nil, err
+		}
+		s.merge(parsed)
+	}
+
+	s.validate(&errs)
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	p.settings = s
+	return s, nil
+}
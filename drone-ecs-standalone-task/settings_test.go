@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestLegacySettingsErrorsNameTheEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		p        Plugin
+		expected string
+	}{
+		{"port mapping fields", Plugin{PortMappings: []string{"80"}}, `port_mappings[0] "80": expected`},
+		{"port mapping number", Plugin{PortMappings: []string{"80 8080", "x 80"}}, "port_mappings[1]: " + hostPortBaseParseErr},
+		{"ulimit fields", Plugin{Ulimits: []string{"nofile 1024"}}, `ulimits[0] "nofile 1024": expected`},
+		{"ulimit number", Plugin{Ulimits: []string{"nofile 1024 max"}}, "ulimits[0]: " + hardLimitBaseParseErr},
+		{"efs volume", Plugin{EfsVolumes: []string{"data fs-1"}}, `efs_volumes[0] "data fs-1": expected`},
+		{"mount point read only", Plugin{MountPoints: []string{"data /data maybe"}}, "mount_points[0]: " + readOnlyBoolBaseParseErr},
+		{"capacity provider", Plugin{CapacityProviders: []string{"1 x FARGATE"}}, "capacity_providers[0]: " + weightParseErr},
+		{"environment variable", Plugin{Environment: []string{"A=1", "B"}}, `environment_variables[1] "B": expected KEY=VALUE`},
+		{"secrets manager variable", Plugin{SecretsManagerEnvironment: []string{"TOKEN"}}, `secrets_manager_variables[0] "TOKEN"`},
+		{"label", Plugin{Labels: []string{"team"}}, `labels[0] "team"`},
+		{"log option", Plugin{LogOptions: []string{"awslogs-group"}}, `log_options[0] "awslogs-group"`},
+		{"ulimit name", Plugin{Ulimits: []string{"files 1 2"}}, `ulimits[0]: name must be one of`},
+		{"ulimit limits", Plugin{Ulimits: []string{"nofile 2048 1024"}}, "ulimits[0] nofile: soft_limit 2048 is greater than hard_limit 1024"},
+	}
+	for _, test := range tests {
+		_, err := test.p.taskSettings()
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), settingsErr) || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected %q in %q", test.name, test.expected, err.Error())
+		}
+	}
+}
+
+func TestSettingsDocumentErrorsNameTheEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		expected string
+	}{
+		{"unknown field", "port_mappings:\n  - container_port: 80\n    container: app\n", "field container not found"},
+		{"unknown setting", "environment:\n  A: 1\n", "field environment not found"},
+		{"wrong type", "port_mappings:\n  - container_port: http\n", "cannot unmarshal"},
+		{"container port", "port_mappings:\n  - container_port: 80\n  - container_port: 70000\n", "port_mappings[1]: container_port must be between 1 and 65535, got 70000"},
+		{"protocol", "port_mappings:\n  - container_port: 80\n    protocol: sctp\n", `port_mappings[0]: protocol must be one of`},
+		{"volume name", "volumes:\n  - source_path: /data\n", "volumes[0]: name is required"},
+		{"efs file system", "efs_volumes:\n  - name: data\n", "efs_volumes[0] data: file_system_id is required"},
+		{"mount point path", "mount_points:\n  - source_volume: data\n", "mount_points[0] data: container_path is required"},
+		{"capacity provider weight", "capacity_providers:\n  - name: FARGATE\n    weight: 2000\n", "capacity_providers[0] FARGATE: weight must be between 0 and 1000, got 2000"},
+		{"empty variable name", "environment_variables:\n  \" \": x\n", "environment_variables: empty name"},
+	}
+	for _, test := range tests {
+		p := &Plugin{Settings: test.document}
+		_, err := p.taskSettings()
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), settingsErr) || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected %q in %q", test.name, test.expected, err.Error())
+		}
+	}
+}
+
+func TestSettingsReportEveryErrorAtOnce(t *testing.T) {
+	p := &Plugin{
+		PortMappings: []string{"80"},
+		Environment:  []string{"A"},
+		Settings:     "volumes:\n  - source_path: /data\n",
+	}
+	_, err := p.taskSettings()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"port_mappings[0]", "environment_variables[0]", "volumes[0]"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err.Error())
+		}
+	}
+}
+
+func TestSettingsMergeLegacyDocumentAndFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "settings.yml")
+	content := "environment_variables:\n  MODE: file\n  REGION: eu\nport_mappings:\n  - container_port: 9090\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := &Plugin{
+		Environment:  []string{"MODE=legacy", "DEBUG=1"},
+		PortMappings: []string{"0 8080"},
+		SettingsFile: file,
+		Settings:     `{"environment_variables": {"MODE": "document"}}`,
+	}
+
+	s, err := p.taskSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"MODE": "document", "REGION": "eu", "DEBUG": "1"}
+	if !reflect.DeepEqual(s.Environment, expected) {
+		t.Errorf("expected %v, got %v", expected, s.Environment)
+	}
+	if len(s.PortMappings) != 2 || s.PortMappings[0].ContainerPort != 8080 || s.PortMappings[1].ContainerPort != 9090 {
+		t.Errorf("expected the legacy port mapping followed by the file's, got %v", s.PortMappings)
+	}
+}
+
+func TestCreateTaskDefinitionSortsEnvironment(t *testing.T) {
+	p := &Plugin{
+		Family:      "job",
+		DockerImage: "example/job",
+		Memory:      512,
+		Environment: []string{"ZONE=b", "APP=job", "MODE=batch"},
+		ecsService:  newFakeECS(),
+	}
+	params, err := p.createTaskDefinition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, pair := range params.ContainerDefinitions[0].Environment {
+		names = append(names, aws.StringValue(pair.Name))
+	}
+	if !reflect.DeepEqual(names, []string{"APP", "MODE", "ZONE"}) {
+		t.Errorf("expected the variables in order, got %v", names)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	shardIndexVariable = "SHARD_INDEX"
+	shardTotalVariable = "SHARD_TOTAL"
+	shardValueVariable = "SHARD_VALUE"
+)
+
+// shardCount returns the number of shards, 0 when the task isn't sharded
+func (p *Plugin) shardCount() int64 {
+	if len(p.ShardValues) > 0 {
+		return int64(len(p.ShardValues))
+	}
+	return p.Shards
+}
+
+// shardOverride returns the overrides of the settings with the shard's
+// variables added to the environment of the override container
+func (p *Plugin) shardOverride(index int64) (*ecs.TaskOverride, error) {
+	override, err := p.setupTaskOverride()
+	if err != nil {
+		return nil, err
+	}
+	if override == nil {
+		override = &ecs.TaskOverride{}
+	}
+
+	name := p.overrideContainerName()
+	var containerOverride *ecs.ContainerOverride
+	for _, existing := range override.ContainerOverrides {
+		if aws.StringValue(existing.Name) == name {
+			containerOverride = existing
+		}
+	}
+	if containerOverride == nil {
+		containerOverride = &ecs.ContainerOverride{Name: aws.String(name)}
+		override.ContainerOverrides = append(override.ContainerOverrides, containerOverride)
+	}
+
+	setOverrideVariable(containerOverride, shardIndexVariable, strconv.FormatInt(index, 10))
+	setOverrideVariable(containerOverride, shardTotalVariable, strconv.FormatInt(p.shardCount(), 10))
+	if len(p.ShardValues) > 0 {
+		setOverrideVariable(containerOverride, shardValueVariable, p.ShardValues[index])
+	}
+	return override, nil
+}
+
+// setOverrideVariable replaces the override's variable with the same name or adds it
+func setOverrideVariable(override *ecs.ContainerOverride, name string, value string) {
+	for _, existing := range override.Environment {
+		if aws.StringValue(existing.Name) == name {
+			existing.Value = aws.String(value)
+			return
+		}
+	}
+	override.Environment = append(override.Environment, &ecs.KeyValuePair{Name: aws.String(name), Value: aws.String(value)})
+}
+
+// resolveShardContainer names the container the shards' variables are set
+// in when no setting does: the only essential container of the task definition
+func (p *Plugin) resolveShardContainer(definitions []*ecs.ContainerDefinition) error {
+	if p.shardCount() == 0 || len(p.OverrideContainerName) != 0 || len(p.ContainerName) != 0 {
+		return nil
+	}
+	essential := []string{}
+	for _, definition := range definitions {
+		if definition.Essential == nil || aws.BoolValue(definition.Essential) {
+			essential = append(essential, aws.StringValue(definition.Name))
+		}
+	}
+	if len(essential) != 1 {
+		return fmt.Errorf(shardContainerErr+"set container_name, the task definition has %d essential containers %s", len(essential), strings.Join(essential, ", "))
+	}
+	p.ContainerName = essential[0]
+	return nil
+}
+
+// shardInputs returns a RunTask input starting a single task per shard
+func (p *Plugin) shardInputs(input *ecs.RunTaskInput) ([]*ecs.RunTaskInput, error) {
+	inputs := []*ecs.RunTaskInput{}
+	for index := int64(0); index < p.shardCount(); index++ {
+		override, err := p.shardOverride(index)
+		if err != nil {
+			return nil, err
+		}
+		shardInput := *input
+		shardInput.Count = aws.Int64(1)
+		shardInput.ClientToken = clientToken(input.ClientToken, fmt.Sprintf("shard-%d", index))
+		shardInput.Overrides = override
+		inputs = append(inputs, &shardInput)
+	}
+	return inputs, nil
+}
+
+// runShards starts the task of every shard from the shards' inputs, skipping
+// the nil ones, returning the started tasks even on error and the shard
+// index of every task
+func (p *Plugin) runShards(ctx context.Context, inputs []*ecs.RunTaskInput) ([]*ecs.Task, map[string]int64, error) {
+	tasks := []*ecs.Task{}
+	shards := map[string]int64{}
+
+	for index, shardInput := range inputs {
+		if shardInput == nil {
+			continue
+		}
+		log.Printf("Starting shard %d of %d\n", index, len(inputs))
+		started, err := p.runTasks(ctx, shardInput)
+		for _, task := range started {
+			shards[aws.StringValue(task.TaskArn)] = int64(index)
+		}
+		tasks = append(tasks, started...)
+		if err != nil {
+			return tasks, shards, err
+		}
+	}
+	return tasks, shards, nil
+}
+
+// setResultShards names the shard of the task of every result
+func (p *Plugin) setResultShards(results []containerResult) {
+	for i := range results {
+		if shard, ok := p.shards[results[i].TaskArn]; ok {
+			results[i].Shard = fmt.Sprintf("%d/%d", shard, p.shardCount())
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func overrideEnvironment(override *ecs.TaskOverride, container string) map[string]string {
+	env := map[string]string{}
+	for _, containerOverride := range override.ContainerOverrides {
+		if aws.StringValue(containerOverride.Name) != container {
+			continue
+		}
+		for _, pair := range containerOverride.Environment {
+			env[aws.StringValue(pair.Name)] = aws.StringValue(pair.Value)
+		}
+	}
+	return env
+}
+
+func TestShardInputsAddShardEnvironment(t *testing.T) {
+	p := &Plugin{
+		ContainerName:       "app",
+		ShardValues:         []string{"unit", "e2e"},
+		OverrideEnvironment: []string{"LEVEL=debug"},
+	}
+
+	inputs, err := p.shardInputs(&ecs.RunTaskInput{Cluster: aws.String("main"), Count: aws.Int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("expected an input per shard, got %d", len(inputs))
+	}
+	for index, value := range []string{"unit", "e2e"} {
+		env := overrideEnvironment(inputs[index].Overrides, "app")
+		if env[shardIndexVariable] != []string{"0", "1"}[index] || env[shardTotalVariable] != "2" || env[shardValueVariable] != value {
+			t.Errorf("unexpected environment of shard %d: %v", index, env)
+		}
+		if env["LEVEL"] != "debug" {
+			t.Errorf("expected the override environment to be kept in shard %d, got %v", index, env)
+		}
+	}
+}
+
+func TestExecRunsEveryShard(t *testing.T) {
+	fake, p := newJob(t)
+	p.Shards = 3
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.runInputs) != 3 {
+		t.Fatalf("expected a RunTask call per shard, got %d", len(fake.runInputs))
+	}
+	for index, input := range fake.runInputs {
+		env := overrideEnvironment(input.Overrides, "app")
+		if env[shardIndexVariable] != []string{"0", "1", "2"}[index] || env[shardTotalVariable] != "3" {
+			t.Errorf("unexpected environment of shard %d: %v", index, env)
+		}
+	}
+
+	shards := map[string]bool{}
+	for _, result := range p.results {
+		shards[result.Shard] = true
+	}
+	if len(shards) != 3 || !shards["0/3"] || !shards["2/3"] {
+		t.Errorf("expected the results of 3 shards, got %v", shards)
+	}
+}
+
+func TestShardInputsReplaceOverrideVariables(t *testing.T) {
+	p := &Plugin{
+		ContainerName:       "app",
+		Shards:              2,
+		OverrideEnvironment: []string{"SHARD_INDEX=9", "LEVEL=debug"},
+	}
+
+	inputs, err := p.shardInputs(&ecs.RunTaskInput{Count: aws.Int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, input := range inputs {
+		variables := input.Overrides.ContainerOverrides[0].Environment
+		names := map[string]int{}
+		for _, pair := range variables {
+			names[aws.StringValue(pair.Name)]++
+		}
+		if names[shardIndexVariable] != 1 || names["LEVEL"] != 1 || len(variables) != 3 {
+			t.Errorf("expected every variable once in shard %d, got %v", index, variables)
+		}
+		if env := overrideEnvironment(input.Overrides, "app"); env[shardIndexVariable] != []string{"0", "1"}[index] {
+			t.Errorf("expected the shard index to replace the override, got %v", env)
+		}
+	}
+}
+
+func TestExecShardsExistingDefinitionInItsEssentialContainer(t *testing.T) {
+	fake, p := newJob(t)
+	p.UseExistingTaskDefinition = true
+	p.ContainerName = ""
+	p.Shards = 2
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	for index, input := range fake.runInputs {
+		if env := overrideEnvironment(input.Overrides, "app"); env[shardIndexVariable] != []string{"0", "1"}[index] {
+			t.Errorf("expected the shard variables in the app container, got %v", input.Overrides)
+		}
+	}
+}
+
+func TestExecShardsRequireContainerAmongEssentialContainers(t *testing.T) {
+	fake := newFakeECS()
+	_, err := fake.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+		Family: aws.String("job"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("example/app:1")},
+			{Name: aws.String("proxy"), Image: aws.String("example/proxy:1"), Essential: aws.Bool(true)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, p := newJob(t, onFake(fake))
+	p.UseExistingTaskDefinition = true
+	p.ContainerName = ""
+	p.Shards = 2
+
+	err = p.Exec()
+	if err == nil || !strings.Contains(err.Error(), shardContainerErr+"set container_name, the task definition has 2 essential containers app, proxy") {
+		t.Errorf("expected the essential containers to be named, got %v", err)
+	}
+	if fake.calls["RunTask"] != 0 {
+		t.Errorf("expected no task to be started, got %d RunTask calls", fake.calls["RunTask"])
+	}
+}
+
+func TestValidateRejectsMismatchingShards(t *testing.T) {
+	p := &Plugin{Shards: 2, ShardValues: []string{"unit", "integration", "e2e"}}
+
+	if err := p.validate(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Singleton modes, telling what to do with the tasks of the group already running
+const (
+	singletonWait = "wait"
+	singletonFail = "fail"
+	singletonStop = "stop"
+)
+
+var singletonModes = []string{singletonWait, singletonFail, singletonStop}
+
+// singletonReason names the build in the reason of the tasks it stops
+func (p *Plugin) singletonReason() string {
+	if len(p.Build.Repo) != 0 && len(p.Build.Number) != 0 {
+		return fmt.Sprintf("Stopped by singleton run of Drone build %s#%s", p.Build.Repo, p.Build.Number)
+	}
+	return "Stopped by singleton run"
+}
+
+// groupTasks returns the ARNs of the tasks of the cluster in the group of
+// the family which are meant to be running. ListTasks can't filter by group,
+// and the tasks of the group may run a task definition of another family,
+// i.e. with like_service, so every running task of the cluster is described
+func (p *Plugin) groupTasks() ([]string, error) {
+	arns := []string{}
+	err := p.ecsService.ListTasksPages(&ecs.ListTasksInput{
+		Cluster:       aws.String(p.Cluster),
+		DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+	}, func(page *ecs.ListTasksOutput, lastPage bool) bool {
+		arns = append(arns, aws.StringValueSlice(page.TaskArns)...)
+		return true
+	})
+	if err != nil || len(arns) == 0 {
+		return nil, err
+	}
+
+	out, err := p.describeTasks(arns)
+	if err != nil {
+		return nil, err
+	}
+	running := []string{}
+	for _, task := range out.Tasks {
+		if aws.StringValue(task.Group) == p.Family && aws.StringValue(task.LastStatus) != ecs.DesiredStatusStopped {
+			running = append(running, aws.StringValue(task.TaskArn))
+		}
+	}
+	return running, nil
+}
+
+// waitForSingleton makes sure no other task of the group runs before the
+// task is started: it fails, or waits at most TaskTimeout seconds for the
+// running tasks to stop, stopping them first in stop mode
+func (p *Plugin) waitForSingleton(ctx context.Context) error {
+	deadline := time.Now().Add(time.Duration(p.TaskTimeout) * time.Second)
+	for {
+		running, err := p.groupTasks()
+		if err != nil {
+			return errors.New(singletonErr + err.Error())
+		}
+		if len(running) == 0 {
+			return nil
+		}
+
+		log.Printf("%d task(s) of group %s already running: %s\n", len(running), p.Family, strings.Join(running, ", "))
+		switch p.Singleton {
+		case singletonFail:
+			return fmt.Errorf(singletonErr+"%d task(s) of group %s already running", len(running), p.Family)
+		case singletonStop:
+			p.stopTasks(running, p.singletonReason())
+		}
+
+		log.Println("Waiting for them to stop")
+		if err := p.waitForStopped(ctx, running, deadline); err != nil {
+			return err
+		}
+	}
+}
+
+// waitForStopped polls the tasks until they are all STOPPED, failing after the deadline
+func (p *Plugin) waitForStopped(ctx context.Context, arns []string, deadline time.Time) error {
+	interval := minPollInterval
+	for {
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf(singletonErr+"%d task(s) of group %s still running after %ds", len(arns), p.Family, p.TaskTimeout)
+		}
+		if !sleep(ctx, interval) {
+			return errCancelled
+		}
+		out, err := p.describeTasks(arns)
+		if err != nil {
+			return errors.New(singletonErr + err.Error())
+		}
+
+		stopped := true
+		for _, task := range out.Tasks {
+			if aws.StringValue(task.LastStatus) != ecs.DesiredStatusStopped {
+				stopped = false
+			}
+		}
+		if stopped {
+			return nil
+		}
+		interval = nextPollInterval(interval, true)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// startGroupTask starts a task of job:1 in the group, as another pipeline would
+func startGroupTask(t *testing.T, fake *fakeECS, group string) *ecs.Task {
+	t.Helper()
+	out, err := fake.RunTask(&ecs.RunTaskInput{
+		Cluster:        aws.String("main"),
+		Group:          aws.String(group),
+		TaskDefinition: aws.String("job:1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake.tasks[aws.StringValue(out.Tasks[0].TaskArn)]
+}
+
+func TestExecSingletonFailsWhileGroupRuns(t *testing.T) {
+	fake, p := newJob(t)
+	startGroupTask(t, fake, "job")
+
+	p.Singleton = singletonFail
+	err := p.Exec()
+	if err == nil || !strings.HasPrefix(err.Error(), singletonErr) {
+		t.Fatalf("expected a singleton error, got %v", err)
+	}
+	if fake.calls["RunTask"] != 1 {
+		t.Errorf("expected no task to be started, got %d RunTask calls", fake.calls["RunTask"])
+	}
+}
+
+func TestExecSingletonIgnoresOtherGroups(t *testing.T) {
+	fake, p := newJob(t)
+	startGroupTask(t, fake, "reports")
+
+	p.Singleton = singletonFail
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls["RunTask"] != 2 {
+		t.Errorf("expected the task to be started, got %d RunTask calls", fake.calls["RunTask"])
+	}
+}
+
+func TestExecSingletonFindsGroupRunningOtherFamily(t *testing.T) {
+	fake, p := newJob(t, withRevision("api"))
+	// the group's task runs the task definition of a service
+	if _, err := fake.RunTask(&ecs.RunTaskInput{
+		Cluster:        aws.String("main"),
+		Group:          aws.String("job"),
+		TaskDefinition: aws.String("api:1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Singleton = singletonFail
+	err := p.Exec()
+	if err == nil || !strings.HasPrefix(err.Error(), singletonErr) {
+		t.Fatalf("expected a singleton error, got %v", err)
+	}
+}
+
+func TestExecSingletonWaitsForGroup(t *testing.T) {
+	fake, p := newJob(t)
+	running := startGroupTask(t, fake, "job")
+
+	p.Singleton = singletonWait
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.StringValue(running.StoppedReason); got != "Essential container in task exited" {
+		t.Errorf("expected the running task to stop by itself, got %q", got)
+	}
+	if fake.calls["StopTask"] != 0 || fake.calls["RunTask"] != 2 {
+		t.Errorf("expected the task to be started once the other stopped, got %v", fake.calls)
+	}
+}
+
+func TestExecSingletonStopsGroup(t *testing.T) {
+	fake, p := newJob(t)
+	running := startGroupTask(t, fake, "job")
+
+	p.Singleton = singletonStop
+	p.Build = Build{Repo: "org/app", Number: "42"}
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.StringValue(running.StoppedReason); got != "Stopped by singleton run of Drone build org/app#42" {
+		t.Errorf("expected the running task to be stopped, got %q", got)
+	}
+	if fake.calls["StopTask"] != 1 || fake.calls["RunTask"] != 2 {
+		t.Errorf("expected the task to be started once the other was stopped, got %v", fake.calls)
+	}
+}
+
+func TestExecSingletonWaitTimesOut(t *testing.T) {
+	fake, p := newJob(t)
+	fake.keepRunning = true
+	startGroupTask(t, fake, "job")
+
+	p.Singleton = singletonWait
+	p.TaskTimeout = 1
+	err := p.Exec()
+	if err == nil || !strings.Contains(err.Error(), "still running after 1s") {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+	if fake.calls["RunTask"] != 1 {
+		t.Errorf("expected no task to be started, got %d RunTask calls", fake.calls["RunTask"])
+	}
+}
+
+func TestValidateSingleton(t *testing.T) {
+	_, p := newJob(t)
+	p.Singleton = "queue"
+	p.Family = ""
+	err := p.validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"singleton must be one of", "singleton requires family"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Build holds the metadata of the Drone build running the plugin
+type Build struct {
+	Repo   string
+	Commit string
+	Number string
+	Link   string
+	Author string
+	Step   string
+}
+
+const (
+	maxTagKeyLength    = 128
+	maxTagValueLength  = 256
+	maxStartedByLength = 128
+
+	buildTagPrefix = "drone:"
+)
+
+var (
+	// characters ECS doesn't allow in tag keys and values
+	invalidTagChars = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+	// characters ECS doesn't allow in startedBy
+	invalidStartedByChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+)
+
+// truncate cuts the value to length characters, the unit of the ECS limits,
+// never splitting a multi-byte character
+func truncate(value string, length int) string {
+	runes := []rune(value)
+	if len(runes) > length {
+		return string(runes[:length])
+	}
+	return value
+}
+
+func sanitizeTag(value string, length int) string {
+	return truncate(invalidTagChars.ReplaceAllString(value, "_"), length)
+}
+
+// buildTags returns the tags describing the Drone build, skipping the ones
+// for which no metadata is available
+func (p *Plugin) buildTags() []*ecs.Tag {
+	values := []struct{ key, value string }{
+		{buildTagPrefix + "repo", p.Build.Repo},
+		{buildTagPrefix + "commit", p.Build.Commit},
+		{buildTagPrefix + "build-number", p.Build.Number},
+		{buildTagPrefix + "build-link", p.Build.Link},
+		{buildTagPrefix + "author", p.Build.Author},
+	}
+
+	tags := []*ecs.Tag{}
+	for _, v := range values {
+		if len(v.value) == 0 {
+			continue
+		}
+		tags = append(tags, &ecs.Tag{
+			Key:   aws.String(v.key),
+			Value: aws.String(sanitizeTag(v.value, maxTagValueLength)),
+		})
+	}
+	return tags
+}
+
+// setupTaskTags merges the build tags with the user supplied `KEY=VALUE`
+// tags, the latter taking precedence
+func (p *Plugin) setupTaskTags() ([]*ecs.Tag, error) {
+	tags := []*ecs.Tag{}
+	if p.BuildTags {
+		tags = p.buildTags()
+	}
+
+	for _, tag := range p.Tags {
+		parts := strings.SplitN(tag, "=", 2)
+		key := strings.Trim(parts[0], " ")
+		if len(parts) != 2 || len(key) == 0 {
+			return nil, errors.New(tagsParseErr + tag)
+		}
+		value := strings.Trim(parts[1], " ")
+
+		override := false
+		for _, existing := range tags {
+			if *existing.Key == key {
+				existing.Value = aws.String(sanitizeTag(value, maxTagValueLength))
+				override = true
+			}
+		}
+		if !override {
+			tags = append(tags, &ecs.Tag{
+				Key:   aws.String(sanitizeTag(key, maxTagKeyLength)),
+				Value: aws.String(sanitizeTag(value, maxTagValueLength)),
+			})
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return tags, nil
+}
+
+// taggingFailure tells whether RunTask failed because ECS can't tag the
+// tasks: without the ecs:TagResource permission, or with the short task ARN
+// format of older accounts
+func taggingFailure(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	message := strings.ToLower(aerr.Message())
+	switch aerr.Code() {
+	case "AccessDeniedException":
+		return strings.Contains(message, "ecs:tagresource")
+	case ecs.ErrCodeInvalidParameterException:
+		return strings.Contains(message, "long arn format")
+	}
+	return false
+}
+
+// withoutBuildTags returns the tags but the build tags, nil when none is left
+func withoutBuildTags(tags []*ecs.Tag) []*ecs.Tag {
+	var kept []*ecs.Tag
+	for _, tag := range tags {
+		if !strings.HasPrefix(aws.StringValue(tag.Key), buildTagPrefix) {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+// startedBy returns the startedBy of the task: the setting if present,
+// otherwise an identifier of the Drone build, or of the step with Idempotent
+func (p *Plugin) startedBy() string {
+	if p.Idempotent {
+		return p.launchIdentity()
+	}
+	if len(p.StartedBy) != 0 {
+		return truncate(invalidStartedByChars.ReplaceAllString(p.StartedBy, "-"), maxStartedByLength)
+	}
+	if len(p.Build.Repo) == 0 {
+		return ""
+	}
+	startedBy := "drone-" + p.Build.Repo
+	if len(p.Build.Number) != 0 {
+		startedBy = startedBy + "-" + p.Build.Number
+	}
+	return truncate(invalidStartedByChars.ReplaceAllString(startedBy, "-"), maxStartedByLength)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestSanitizeTagKeepsCharactersWhole(t *testing.T) {
+	tests := []struct {
+		value    string
+		length   int
+		expected string
+	}{
+		{"main", 10, "main"},
+		{"feature/login", 7, "feature"},
+		{"Søren Ærø", 7, "Søren Æ"},
+		{"日本語のコミット", 3, "日本語"},
+		{"fix: #42 (hotfix)", 20, "fix: _42 _hotfix_"},
+	}
+	for _, test := range tests {
+		got := sanitizeTag(test.value, test.length)
+		if got != test.expected {
+			t.Errorf("%q cut to %d: expected %q, got %q", test.value, test.length, test.expected, got)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%q cut to %d: invalid UTF-8 %q", test.value, test.length, got)
+		}
+	}
+}
+
+func tagValues(tags []*ecs.Tag) map[string]string {
+	values := map[string]string{}
+	for _, tag := range tags {
+		values[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return values
+}
+
+func TestSetupTaskTagsMergesBuildTags(t *testing.T) {
+	p := &Plugin{
+		BuildTags: true,
+		Build:     Build{Repo: "org/app", Commit: "abc123", Number: "42", Author: "jane doe"},
+		Tags:      []string{"team = data", "drone:author=release-bot"},
+	}
+	tags, err := p.setupTaskTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := tagValues(tags)
+	expected := map[string]string{
+		"drone:repo":         "org/app",
+		"drone:commit":       "abc123",
+		"drone:build-number": "42",
+		"drone:author":       "release-bot",
+		"team":               "data",
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+	if len(tags) != len(expected) {
+		t.Errorf("expected the author tag to be replaced, got %d tags", len(tags))
+	}
+}
+
+func TestSetupTaskTagsWithoutBuildTags(t *testing.T) {
+	p := &Plugin{Build: Build{Repo: "org/app"}}
+	if tags, err := p.setupTaskTags(); err != nil || tags != nil {
+		t.Errorf("expected no tags, got %v, %v", tags, err)
+	}
+
+	p.Tags = []string{"=data"}
+	if _, err := p.setupTaskTags(); err == nil || err.Error() != tagsParseErr+"=data" {
+		t.Errorf("expected a tags error, got %v", err)
+	}
+}
+
+func TestStartedBy(t *testing.T) {
+	tests := []struct {
+		name     string
+		p        Plugin
+		expected string
+	}{
+		{"build", Plugin{Build: Build{Repo: "org/app", Number: "42"}}, "drone-org-app-42"},
+		{"without number", Plugin{Build: Build{Repo: "org/app"}}, "drone-org-app"},
+		{"without build", Plugin{}, ""},
+		{"setting", Plugin{StartedBy: "nightly report", Build: Build{Repo: "org/app"}}, "nightly-report"},
+		{"too long", Plugin{Build: Build{Repo: strings.Repeat("a", 200)}}, "drone-" + strings.Repeat("a", maxStartedByLength-len("drone-"))},
+	}
+	for _, test := range tests {
+		if got := test.p.startedBy(); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestExecStartsUntaggedWhenTaggingFails(t *testing.T) {
+	fake, p := newJob(t)
+	fake.shortArns = true
+	p.BuildTags = true
+	p.Build = Build{Repo: "org/app", Number: "42"}
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.runInputs) != 2 || len(fake.runInputs[0].Tags) == 0 {
+		t.Fatalf("expected a tagged call then an untagged one, got %d calls", len(fake.runInputs))
+	}
+	if tags := fake.runInputs[1].Tags; tags != nil {
+		t.Errorf("expected no tags, got %v", tags)
+	}
+	if got := aws.StringValue(fake.runInputs[1].StartedBy); got != "drone-org-app-42" {
+		t.Errorf("expected startedBy to be kept, got %s", got)
+	}
+
+	// user tags are not dropped, ECS refusing them fails the step
+	_, p = newJob(t, onFake(fake))
+	p.Tags = []string{"team=data"}
+	if err := p.Exec(); err == nil {
+		t.Error("expected the tags setting to fail the step")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// targetTemplate is a cluster the task runs in, as declared in the `targets`
+// setting. Empty fields default to the plugin's settings
+type targetTemplate struct {
+	Region         string   `json:"region"`
+	Cluster        string   `json:"cluster"`
+	UserRoleArn    string   `json:"user_role_arn"`
+	Subnets        []string `json:"service_network_subnets"`
+	SecurityGroups []string `json:"service_network_security_groups"`
+}
+
+// targetResult is the outcome of the task in a single target
+type targetResult struct {
+	Target  string
+	Err     error
+	Results []containerResult
+}
+
+// parseTargets returns a copy of the plugin per target
+func (p *Plugin) parseTargets() ([]*Plugin, error) {
+	var templates []targetTemplate
+	if err := json.Unmarshal([]byte(p.Targets), &templates); err != nil {
+		return nil, errors.New(targetsParseErr + err.Error())
+	}
+	if len(templates) == 0 {
+		return nil, errors.New(targetsParseErr + "no target declared")
+	}
+
+	// Settings are parsed once and shared by the targets
+	if _, err := p.taskSettings(); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	targets := []*Plugin{}
+	for i, template := range templates {
+		target := *p
+		target.Targets = ""
+		if len(template.Region) != 0 {
+			target.Region = template.Region
+		}
+		if len(template.Cluster) != 0 {
+			target.Cluster = template.Cluster
+		}
+		if len(template.UserRoleArn) != 0 {
+			target.UserRoleArn = template.UserRoleArn
+		}
+		if len(template.Subnets) != 0 {
+			target.ServiceNetworkSubnets = template.Subnets
+		}
+		if len(template.SecurityGroups) != 0 {
+			target.ServiceNetworkSecurityGroups = template.SecurityGroups
+		}
+
+		if len(target.Cluster) == 0 {
+			return nil, fmt.Errorf(targetsParseErr+"target %d: cluster is required", i)
+		}
+		target.target = target.Region + "/" + target.Cluster
+		if seen[target.target] {
+			return nil, fmt.Errorf(targetsParseErr+"target %s is declared twice", target.target)
+		}
+		seen[target.target] = true
+
+		targets = append(targets, &target)
+	}
+	return targets, nil
+}
+
+// execTargets runs the task in every target concurrently, each target
+// registering its own task definition and tracking its own tasks. Unless
+// FailFast is set, a failing target doesn't stop the others
+func (p *Plugin) execTargets(ctx context.Context) error {
+	targets, err := p.parseTargets()
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+
+	// Every target is validated before any of them starts
+	invalid := []string{}
+	for _, target := range targets {
+		if err := target.validate(); err != nil {
+			invalid = append(invalid, target.target+": "+err.Error())
+		}
+	}
+	if len(invalid) != 0 {
+		err := errors.New(strings.Join(invalid, "\n"))
+		log.Println(err.Error())
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]targetResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		run := func(i int, target *Plugin) {
+			log.Printf("Running the task in %s\n", target.target)
+			err := target.execTarget(ctx)
+			results[i] = targetResult{Target: target.target, Err: err, Results: target.results}
+			if err != nil && err != errCancelled && p.FailFast {
+				log.Printf("Target %s failed, cancelling the other targets\n", target.target)
+				cancel()
+			}
+		}
+
+		if p.DryRun {
+			// keep the printed requests of the targets apart
+			run(i, target)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, target *Plugin) {
+			defer wg.Done()
+			run(i, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	printTargetSummary(os.Stdout, results)
+	return p.targetsError(results)
+}
+
+// printTargetSummary writes the containers' results of every target
+// followed by a table of the targets' outcomes
+func printTargetSummary(out io.Writer, results []targetResult) {
+	for _, result := range results {
+		if len(result.Results) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "Results of %s:\n", result.Target)
+		printResultSummary(out, result.Results)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tRESULT")
+	for _, result := range results {
+		outcome := "succeeded"
+		switch {
+		case result.Err == errCancelled:
+			outcome = "cancelled"
+		case result.Err != nil:
+			outcome = "failed: " + result.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\n", result.Target, outcome)
+	}
+	w.Flush()
+}
+
+// targetsError fails when a target failed or was cancelled. The exit code
+// of the first failed target is propagated
+func (p *Plugin) targetsError(results []targetResult) error {
+	failed := []string{}
+	var exitErr *ExitError
+	for _, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		failed = append(failed, result.Target)
+		var resultExitErr *ExitError
+		if exitErr == nil && errors.As(result.Err, &resultExitErr) {
+			exitErr = resultExitErr
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf(targetsFailedErr+"%s", strings.Join(failed, ", "))
+	if exitErr != nil {
+		return &ExitError{Code: exitErr.Code, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func runClusters(fake *fakeECS) []string {
+	clusters := []string{}
+	for _, input := range fake.runInputs {
+		clusters = append(clusters, aws.StringValue(input.Cluster))
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+func TestParseTargetsDefaultsToPluginSettings(t *testing.T) {
+	p := &Plugin{
+		Region:                "eu-west-1",
+		Cluster:               "main",
+		ServiceNetworkSubnets: []string{"subnet-a"},
+		Targets:               `[{"cluster": "dk"}, {"region": "eu-north-1", "service_network_subnets": ["subnet-b"]}]`,
+	}
+
+	targets, err := p.parseTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(targets))
+	}
+	if targets[0].target != "eu-west-1/dk" || targets[0].ServiceNetworkSubnets[0] != "subnet-a" {
+		t.Errorf("unexpected first target %s %v", targets[0].target, targets[0].ServiceNetworkSubnets)
+	}
+	if targets[1].target != "eu-north-1/main" || targets[1].ServiceNetworkSubnets[0] != "subnet-b" {
+		t.Errorf("unexpected second target %s %v", targets[1].target, targets[1].ServiceNetworkSubnets)
+	}
+}
+
+func TestParseTargetsRejectsDuplicates(t *testing.T) {
+	p := &Plugin{Region: "eu-west-1", Targets: `[{"cluster": "dk"}, {"cluster": "dk", "region": "eu-west-1"}]`}
+
+	if _, err := p.parseTargets(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestParseTargetsReturnsSettingsError(t *testing.T) {
+	p := &Plugin{
+		Cluster: "main",
+		Ulimits: []string{"nofile 1024 max"},
+		Targets: `[{"cluster": "dk"}, {"cluster": "se"}]`,
+	}
+
+	targets, err := p.parseTargets()
+	if err == nil || !strings.HasPrefix(err.Error(), settingsErr) {
+		t.Fatalf("expected the settings error, got %v", err)
+	}
+	if targets != nil {
+		t.Errorf("expected no target, got %d", len(targets))
+	}
+}
+
+func TestExecTargetsRunsEveryTarget(t *testing.T) {
+	fake, p := newJob(t)
+	p.Region = "eu-west-1"
+	p.Targets = `[{"cluster": "dk"}, {"cluster": "se"}]`
+
+	if err := p.execTargets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if clusters := runClusters(fake); len(clusters) != 2 || clusters[0] != "dk" || clusters[1] != "se" {
+		t.Errorf("expected the task to run in dk and se, got %v", clusters)
+	}
+}
+
+func TestExecTargetsRunsAllDespiteFailure(t *testing.T) {
+	fake, p := newJob(t)
+	fake.missingClusters["dk"] = true
+	p.Region = "eu-west-1"
+	p.Targets = `[{"cluster": "dk"}, {"cluster": "se"}]`
+
+	err := p.execTargets(context.Background())
+	if err == nil || err.Error() != targetsFailedErr+"eu-west-1/dk" {
+		t.Fatalf("expected only dk to fail, got %v", err)
+	}
+	for arn, task := range fake.tasks {
+		if aws.StringValue(task.StoppedReason) != "Essential container in task exited" {
+			t.Errorf("expected task %s to run to completion, got %s", arn, aws.StringValue(task.StoppedReason))
+		}
+	}
+}
+
+func TestExecTargetsFailFastCancelsOtherTargets(t *testing.T) {
+	fake, p := newJob(t)
+	fake.missingClusters["dk"] = true
+	fake.keepRunning = true
+	p.Region = "eu-west-1"
+	p.Targets = `[{"cluster": "dk"}, {"cluster": "se"}]`
+	p.FailFast = true
+	p.CancelStopTimeout = 5
+
+	err := p.execTargets(context.Background())
+	if err == nil || err.Error() != targetsFailedErr+"eu-west-1/dk, eu-west-1/se" {
+		t.Fatalf("expected dk to fail and se to be cancelled, got %v", err)
+	}
+	// se is cancelled either before or after its task is started
+	for arn, task := range fake.tasks {
+		if aws.StringValue(task.LastStatus) != "STOPPED" {
+			t.Errorf("expected task %s to be stopped, got %s", arn, aws.StringValue(task.LastStatus))
+		}
+	}
+}
+
+func TestExecTargetsPropagatesExitCode(t *testing.T) {
+	fake, p := newJob(t)
+	fake.exitCodes["app"] = 4
+	p.Region = "eu-west-1"
+	p.Targets = `[{"cluster": "dk"}, {"cluster": "se"}]`
+	p.PropagateExitCode = true
+
+	var exitErr *ExitError
+	if err := p.execTargets(context.Background()); !errors.As(err, &exitErr) || exitErr.Code != 4 {
+		t.Fatalf("expected exit code 4, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"gopkg.in/yaml.v3"
+)
+
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandPlaceholders replaces `${VAR}` placeholders with the value of the
+// environment variable. Undefined variables are reported all at once
+func expandPlaceholders(content string) (string, error) {
+	missing := []string{}
+	expanded := placeholder.ReplaceAllStringFunc(content, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", errors.New("undefined variables: " + strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// yamlToJSON converts a yaml document to json, so it can be decoded into
+// the AWS SDK structs. They have no json tags: encoding/json matches the
+// camelCase keys to the field names case-insensitively
+func yamlToJSON(content []byte) ([]byte, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
+
+// loadTaskDefinitionFile reads the task definition file, in the shape of
+// RegisterTaskDefinitionInput (the output of `aws ecs describe-task-definition`
+// works too, unknown fields are ignored)
+func (p *Plugin) loadTaskDefinitionFile() (*ecs.RegisterTaskDefinitionInput, error) {
+	content, err := os.ReadFile(p.TaskDefinitionFile)
+	if err != nil {
+		return nil, errors.New(taskDefinitionFileErr + err.Error())
+	}
+
+	expanded, err := expandPlaceholders(string(content))
+	if err != nil {
+		return nil, errors.New(taskDefinitionFileErr + err.Error())
+	}
+	content = []byte(expanded)
+
+	switch strings.ToLower(filepath.Ext(p.TaskDefinitionFile)) {
+	case ".yaml", ".yml":
+		content, err = yamlToJSON(content)
+		if err != nil {
+			return nil, errors.New(taskDefinitionFileErr + err.Error())
+		}
+	}
+
+	var input struct {
+		ecs.RegisterTaskDefinitionInput
+		// describe-task-definition output wraps the definition
+		TaskDefinition *ecs.RegisterTaskDefinitionInput `json:"taskDefinition"`
+	}
+	if err := json.Unmarshal(content, &input); err != nil {
+		return nil, errors.New(taskDefinitionFileErr + err.Error())
+	}
+
+	template := &input.RegisterTaskDefinitionInput
+	if input.TaskDefinition != nil {
+		template = input.TaskDefinition
+	}
+	if len(template.ContainerDefinitions) == 0 {
+		return nil, errors.New(taskDefinitionFileErr + "no containerDefinitions found")
+	}
+
+	return template, nil
+}
+
+// taskDefinitionFromInput turns a task definition template into the shape
+// of a described task definition, so it is merged with the settings the
+// same way as an existing task definition is
+func taskDefinitionFromInput(input *ecs.RegisterTaskDefinitionInput) *ecs.TaskDefinition {
+	return &ecs.TaskDefinition{
+		ContainerDefinitions:    input.ContainerDefinitions,
+		Cpu:                     input.Cpu,
+		EphemeralStorage:        input.EphemeralStorage,
+		ExecutionRoleArn:        input.ExecutionRoleArn,
+		Family:                  input.Family,
+		InferenceAccelerators:   input.InferenceAccelerators,
+		IpcMode:                 input.IpcMode,
+		Memory:                  input.Memory,
+		NetworkMode:             input.NetworkMode,
+		PidMode:                 input.PidMode,
+		PlacementConstraints:    input.PlacementConstraints,
+		ProxyConfiguration:      input.ProxyConfiguration,
+		RequiresCompatibilities: input.RequiresCompatibilities,
+		RuntimePlatform:         input.RuntimePlatform,
+		TaskRoleArn:             input.TaskRoleArn,
+		Volumes:                 input.Volumes,
+	}
+}
+
+// imageWithTag replaces the tag of the image, keeping a registry port intact
+func imageWithTag(image string, tag string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + ":" + tag
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+const (
+	maxSecurityGroups = 5
+	maxSubnets        = 16
+)
+
+// fargateMemory lists the memory values (MiB) Fargate supports per task CPU units
+var fargateMemory = map[int64]func(memory int64) bool{
+	256:   func(m int64) bool { return m == 512 || m == 1024 || m == 2048 },
+	512:   func(m int64) bool { return m >= 1024 && m <= 4096 && m%1024 == 0 },
+	1024:  func(m int64) bool { return m >= 2048 && m <= 8192 && m%1024 == 0 },
+	2048:  func(m int64) bool { return m >= 4096 && m <= 16384 && m%1024 == 0 },
+	4096:  func(m int64) bool { return m >= 8192 && m <= 30720 && m%1024 == 0 },
+	8192:  func(m int64) bool { return m >= 16384 && m <= 61440 && m%4096 == 0 },
+	16384: func(m int64) bool { return m >= 32768 && m <= 122880 && m%8192 == 0 },
+}
+
+var unitValue = regexp.MustCompile(`^(?i)\s*([0-9.]+)\s*(vcpu|gb)?\s*$`)
+
+// parseTaskSize parses task cpu (`1024`, `1 vCPU`) or memory (`2048`, `2 GB`)
+// into cpu units or MiB
+func parseTaskSize(value string) (int64, error) {
+	match := unitValue.FindStringSubmatch(value)
+	if match == nil {
+		return 0, errors.New("expected a number, optionally followed by vCPU or GB")
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	if len(match[2]) != 0 {
+		// both a vCPU and a GB are 1024 units
+		number = number * 1024
+	}
+	return int64(number), nil
+}
+
+// fargate tells whether the task runs on FARGATE: by launch type, or by the
+// FARGATE capacity providers of like_service used in its place
+func (p *Plugin) fargate() bool {
+	if contains(strings.Fields(p.Compatibilities), ecs.CompatibilityFargate) {
+		return true
+	}
+	if p.likeService == nil || len(p.Compatibilities) != 0 || len(p.CapacityProviders) != 0 {
+		return false
+	}
+	for _, item := range p.likeService.CapacityProviderStrategy {
+		// FARGATE and FARGATE_SPOT
+		if strings.HasPrefix(aws.StringValue(item.CapacityProvider), ecs.CompatibilityFargate) {
+			return true
+		}
+	}
+	return false
+}
+
+// effectiveNetworkMode returns the network mode the task runs with, as far
+// as it is known before reading the task definition: empty when it comes
+// from a task definition still to be read
+func (p *Plugin) effectiveNetworkMode() string {
+	switch {
+	case len(p.NetworkMode) != 0:
+		return p.NetworkMode
+	case p.fargate():
+		return ecs.NetworkModeAwsvpc
+	case len(p.ExistingTaskDefinitionArn) == 0 && len(p.TaskDefinitionFile) == 0 && len(p.TaskDefinitionFromService) == 0:
+		return p.defaultNetworkMode()
+	}
+	return ""
+}
+
+// validate checks the settings, including the ones like_service filled in,
+// before the task definition is read and reports every problem at once
+func (p *Plugin) validate() error {
+	errs := settingsErrors{}
+
+	settings, err := p.taskSettings()
+	if err != nil {
+		errs = append(errs, strings.TrimPrefix(err.Error(), settingsErr))
+	}
+
+	if _, _, err := p.setupPlacement(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	for _, compatibility := range strings.Fields(p.Compatibilities) {
+		if !contains(ecs.Compatibility_Values(), compatibility) {
+			errs.add("compatibilities: %q must be one of %v", compatibility, ecs.Compatibility_Values())
+		}
+	}
+
+	if p.fargate() {
+		if p.Privileged {
+			errs.add("privileged is not supported by FARGATE")
+		}
+		if len(p.NetworkMode) != 0 && p.NetworkMode != ecs.NetworkModeAwsvpc {
+			errs.add("network_mode must be awsvpc with FARGATE, got %s", p.NetworkMode)
+		}
+		if len(p.TaskCPU) != 0 && len(p.TaskMemory) != 0 {
+			cpu, cpuErr := parseTaskSize(p.TaskCPU)
+			memory, memoryErr := parseTaskSize(p.TaskMemory)
+			if cpuErr != nil {
+				errs.add("task_cpu %q: %s", p.TaskCPU, cpuErr.Error())
+			}
+			if memoryErr != nil {
+				errs.add("task_memory %q: %s", p.TaskMemory, memoryErr.Error())
+			}
+			if cpuErr == nil && memoryErr == nil {
+				if valid, ok := fargateMemory[cpu]; !ok {
+					errs.add("task_cpu %s is not supported by FARGATE, use 256, 512, 1024, 2048, 4096, 8192 or 16384", p.TaskCPU)
+				} else if !valid(memory) {
+					errs.add("task_memory %s is not supported by FARGATE with task_cpu %s", p.TaskMemory, p.TaskCPU)
+				}
+			}
+		}
+	}
+
+	if p.effectiveNetworkMode() == ecs.NetworkModeAwsvpc {
+		if len(p.ServiceNetworkSubnets) == 0 {
+			errs.add("service_network_subnets are required with awsvpc network mode, unless taken from like_service")
+		}
+		if settings != nil {
+			for i, portMapping := range settings.PortMappings {
+				if portMapping.HostPort != 0 && portMapping.HostPort != portMapping.ContainerPort {
+					errs.add("port_mappings[%d]: host_port must be empty or equal to container_port %d with awsvpc network mode, got %d", i, portMapping.ContainerPort, portMapping.HostPort)
+				}
+			}
+		}
+	}
+	if len(p.ServiceNetworkSecurityGroups) > maxSecurityGroups {
+		errs.add("service_network_security_groups: at most %d security groups are allowed, got %d", maxSecurityGroups, len(p.ServiceNetworkSecurityGroups))
+	}
+	if len(p.ServiceNetworkSubnets) > maxSubnets {
+		errs.add("service_network_subnets: at most %d subnets are allowed, got %d", maxSubnets, len(p.ServiceNetworkSubnets))
+	}
+	if len(p.ServiceNetworkAssignPublicIP) != 0 && !contains(ecs.AssignPublicIp_Values(), p.ServiceNetworkAssignPublicIP) {
+		errs.add("service_network_assign_public_ip must be one of %v, got %s", ecs.AssignPublicIp_Values(), p.ServiceNetworkAssignPublicIP)
+	}
+
+	if len(p.TaskDefinitionFromService) != 0 && len(p.ExistingTaskDefinitionArn) != 0 {
+		errs.add("task_definition_from_service and existing_task_definition_arn can't be set together")
+	}
+
+	// override variables are checked before a task definition is registered
+	for i, envVar := range p.OverrideEnvironment {
+		splitKeyValue("override_environment_variables", i, envVar, &errs)
+	}
+
+	if p.Shards < 0 {
+		errs.add("shards must not be negative, got %d", p.Shards)
+	}
+	if p.Shards > 0 && len(p.ShardValues) > 0 && p.Shards != int64(len(p.ShardValues)) {
+		errs.add("shards is %d but %d shard_values are given, a shard is started per value", p.Shards, len(p.ShardValues))
+	}
+	if p.shardCount() > 0 && p.DesiredCount > 1 {
+		errs.add("desired_count must be empty or 1 with shards, each shard starts a single task")
+	}
+
+	if len(p.Singleton) != 0 {
+		if !contains(singletonModes, p.Singleton) {
+			errs.add("singleton must be one of %v, got %s", singletonModes, p.Singleton)
+		}
+		if len(p.Family) == 0 {
+			errs.add("singleton requires family, the group of the tasks")
+		}
+	}
+
+	if p.Idempotent {
+		if len(p.Build.Repo) == 0 || len(p.Build.Number) == 0 || len(p.Build.Step) == 0 {
+			errs.add("idempotent requires the Drone repo, build number and step name")
+		}
+		if len(p.StartedBy) != 0 {
+			errs.add("started_by can't be set with idempotent, which sets it")
+		}
+	}
+
+	if len(p.HealthCheckCommand) != 0 {
+		if p.HealthCheckInterval < 5 || p.HealthCheckInterval > 300 {
+			errs.add("healthcheck_interval must be between 5 and 300 seconds, got %d", p.HealthCheckInterval)
+		}
+		if p.HealthCheckRetries < 1 || p.HealthCheckRetries > 10 {
+			errs.add("healthcheck_retries must be between 1 and 10, got %d", p.HealthCheckRetries)
+		}
+		if p.HealthCheckStartPeriod < 0 || p.HealthCheckStartPeriod > 300 {
+			errs.add("healthcheck_start_period must be between 0 and 300 seconds, got %d", p.HealthCheckStartPeriod)
+		}
+		if p.HealthCheckTimeout < 2 || p.HealthCheckTimeout > 60 {
+			errs.add("healthcheck_timeout must be between 2 and 60 seconds, got %d", p.HealthCheckTimeout)
+		}
+		if first := p.HealthCheckCommand[0]; first != "CMD" && first != "CMD-SHELL" {
+			errs.add("healthcheck_command must start with CMD or CMD-SHELL, got %s", first)
+		}
+	}
+
+	return errs.err()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	subnets := func(n int) []string {
+		s := []string{}
+		for i := 0; i < n; i++ {
+			s = append(s, "subnet")
+		}
+		return s
+	}
+	fargate := func(p *Plugin) {
+		p.Compatibilities = "FARGATE"
+		p.ServiceNetworkSubnets = []string{"subnet-a"}
+		p.TaskCPU = "256"
+		p.TaskMemory = "512"
+	}
+	healthcheck := func(p *Plugin) {
+		p.HealthCheckCommand = []string{"CMD-SHELL", "true"}
+		p.HealthCheckInterval = 30
+		p.HealthCheckRetries = 3
+		p.HealthCheckTimeout = 5
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(p *Plugin)
+		expected string
+	}{
+		{"ec2 with existing definition", func(p *Plugin) {}, ""},
+		{"fargate", fargate, ""},
+		{"fargate with units", func(p *Plugin) { fargate(p); p.TaskCPU = "1 vCPU"; p.TaskMemory = "2 GB" }, ""},
+		{"fargate largest", func(p *Plugin) { fargate(p); p.TaskCPU = "16384"; p.TaskMemory = "122880" }, ""},
+		{"fargate memory too large", func(p *Plugin) { fargate(p); p.TaskMemory = "4096" }, "task_memory 4096 is not supported by FARGATE with task_cpu 256"},
+		{"fargate memory step", func(p *Plugin) { fargate(p); p.TaskCPU = "8192"; p.TaskMemory = "18432" }, "task_memory 18432 is not supported by FARGATE with task_cpu 8192"},
+		{"fargate cpu", func(p *Plugin) { fargate(p); p.TaskCPU = "3000" }, "task_cpu 3000 is not supported by FARGATE"},
+		{"fargate cpu format", func(p *Plugin) { fargate(p); p.TaskCPU = "lots" }, `task_cpu "lots": expected a number`},
+		{"fargate privileged", func(p *Plugin) { fargate(p); p.Privileged = true }, "privileged is not supported by FARGATE"},
+		{"fargate network mode", func(p *Plugin) { fargate(p); p.NetworkMode = "bridge" }, "network_mode must be awsvpc with FARGATE, got bridge"},
+		{"fargate without subnets", func(p *Plugin) { fargate(p); p.ServiceNetworkSubnets = nil }, "service_network_subnets are required with awsvpc network mode"},
+		{"new fargate definition without subnets", func(p *Plugin) {
+			fargate(p)
+			p.ExistingTaskDefinitionArn = ""
+			p.ServiceNetworkSubnets = nil
+		}, "service_network_subnets are required with awsvpc network mode"},
+		{"compatibility", func(p *Plugin) { p.Compatibilities = "LAMBDA" }, `compatibilities: "LAMBDA" must be one of`},
+		{"awsvpc host port", func(p *Plugin) {
+			fargate(p)
+			p.PortMappings = []string{"80 8080"}
+		}, "port_mappings[0]: host_port must be empty or equal to container_port 8080 with awsvpc network mode, got 80"},
+		{"security groups", func(p *Plugin) { p.ServiceNetworkSecurityGroups = subnets(6) }, "at most 5 security groups are allowed, got 6"},
+		{"subnets", func(p *Plugin) { p.ServiceNetworkSubnets = subnets(17) }, "at most 16 subnets are allowed, got 17"},
+		{"public ip", func(p *Plugin) { p.ServiceNetworkAssignPublicIP = "YES" }, "service_network_assign_public_ip must be one of"},
+		{"healthcheck", healthcheck, ""},
+		{"healthcheck interval", func(p *Plugin) { healthcheck(p); p.HealthCheckInterval = 4 }, "healthcheck_interval must be between 5 and 300 seconds, got 4"},
+		{"healthcheck retries", func(p *Plugin) { healthcheck(p); p.HealthCheckRetries = 11 }, "healthcheck_retries must be between 1 and 10, got 11"},
+		{"healthcheck start period", func(p *Plugin) { healthcheck(p); p.HealthCheckStartPeriod = 301 }, "healthcheck_start_period must be between 0 and 300 seconds, got 301"},
+		{"healthcheck timeout", func(p *Plugin) { healthcheck(p); p.HealthCheckTimeout = 61 }, "healthcheck_timeout must be between 2 and 60 seconds, got 61"},
+		{"healthcheck command", func(p *Plugin) { healthcheck(p); p.HealthCheckCommand = []string{"true"} }, "healthcheck_command must start with CMD or CMD-SHELL, got true"},
+	}
+	for _, test := range tests {
+		_, p := newJob(t)
+		test.setup(p)
+		err := p.validate()
+		switch {
+		case len(test.expected) == 0 && err != nil:
+			t.Errorf("%s: expected no error, got %v", test.name, err)
+		case len(test.expected) != 0 && err == nil:
+			t.Errorf("%s: expected %q, got no error", test.name, test.expected)
+		case len(test.expected) != 0 && !strings.Contains(err.Error(), test.expected):
+			t.Errorf("%s: expected %q in %q", test.name, test.expected, err.Error())
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	_, p := newJob(t)
+	p.Compatibilities = "FARGATE"
+	p.Privileged = true
+	p.ServiceNetworkSecurityGroups = []string{"a", "b", "c", "d", "e", "f"}
+	err := p.validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"privileged", "service_network_subnets", "security groups"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err.Error())
+		}
+	}
+}
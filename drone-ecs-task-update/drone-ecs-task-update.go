@@ -76,6 +76,16 @@ func main() {
 			Usage:  "Force new deployment of the service if image was not changed",
 			EnvVar: "PLUGIN_FORCE_NEW_DEPLOYMENT",
 		},
+		cli.Int64Flag{
+			Name:   "keep-revisions",
+			Usage:  "Number of ACTIVE revisions of the family kept after a successful update, older ones are deregistered. 0 disables pruning",
+			EnvVar: "PLUGIN_KEEP_REVISIONS",
+		},
+		cli.BoolFlag{
+			Name:   "prune-dry-run",
+			Usage:  "Only list the revisions which would be deregistered",
+			EnvVar: "PLUGIN_PRUNE_DRY_RUN",
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
@@ -95,6 +105,8 @@ func run(c *cli.Context) error {
 		Cluster:            c.String("cluster"),
 		IgnoreMissing:      c.Bool("ignore-missing-container"),
 		ForceNewDeployment: c.Bool("force-new-deployment"),
+		KeepRevisions:      c.Int64("keep-revisions"),
+		PruneDryRun:        c.Bool("prune-dry-run"),
 	}
 	return plugin.Exec()
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+)
+
+const fakeArnPrefix = "arn:aws:ecs:eu-west-1:123456789012:"
+
+type fakeRevision struct {
+	definition *ecs.TaskDefinition
+	tags       []*ecs.Tag
+}
+
+// fakeECS is an in-memory ECS simulating task definitions and the
+// deployments of services
+type fakeECS struct {
+	ecsiface.ECSAPI
+
+	mu              sync.Mutex
+	taskDefinitions map[string][]*fakeRevision
+	services        map[string]*ecs.Service
+
+	calls        map[string]int
+	updateInputs []*ecs.UpdateServiceInput
+}
+
+func newFakeECS() *fakeECS {
+	return &fakeECS{
+		taskDefinitions: map[string][]*fakeRevision{},
+		services:        map[string]*ecs.Service{},
+		calls:           map[string]int{},
+	}
+}
+
+func copyTags(tags []*ecs.Tag) []*ecs.Tag {
+	copied := []*ecs.Tag{}
+	for _, tag := range tags {
+		copied = append(copied, &ecs.Tag{Key: aws.String(aws.StringValue(tag.Key)), Value: aws.String(aws.StringValue(tag.Value))})
+	}
+	return copied
+}
+
+func (f *fakeECS) call(name string) {
+	f.calls[name]++
+}
+
+// revision finds a task definition by family, family:revision or ARN
+func (f *fakeECS) revision(taskDefinition string) (*fakeRevision, error) {
+	name := revisionName(taskDefinition)
+	parts := strings.SplitN(name, ":", 2)
+	revisions := f.taskDefinitions[parts[0]]
+	if len(parts) == 2 {
+		number, err := strconv.Atoi(parts[1])
+		if err != nil || number < 1 || number > len(revisions) {
+			return nil, awserr.New(ecs.ErrCodeClientException, "Unable to describe task definition.", nil)
+		}
+		return revisions[number-1], nil
+	}
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if aws.StringValue(revisions[i].definition.Status) == ecs.TaskDefinitionStatusActive {
+			return revisions[i], nil
+		}
+	}
+	return nil, awserr.New(ecs.ErrCodeClientException, "Unable to describe task definition.", nil)
+}
+
+func (f *fakeECS) RegisterTaskDefinition(input *ecs.RegisterTaskDefinitionInput) (*ecs.RegisterTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("RegisterTaskDefinition")
+
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	family := aws.StringValue(input.Family)
+	number := len(f.taskDefinitions[family]) + 1
+	definition := &ecs.TaskDefinition{
+		Family:                  input.Family,
+		ContainerDefinitions:    input.ContainerDefinitions,
+		Cpu:                     input.Cpu,
+		Memory:                  input.Memory,
+		NetworkMode:             input.NetworkMode,
+		RequiresCompatibilities: input.RequiresCompatibilities,
+		TaskRoleArn:             input.TaskRoleArn,
+		ExecutionRoleArn:        input.ExecutionRoleArn,
+		Volumes:                 input.Volumes,
+		Revision:                aws.Int64(int64(number)),
+		Status:                  aws.String(ecs.TaskDefinitionStatusActive),
+		TaskDefinitionArn:       aws.String(fmt.Sprintf("%stask-definition/%s:%d", fakeArnPrefix, family, number)),
+	}
+	revision := &fakeRevision{definition: &ecs.TaskDefinition{}}
+	awsutil.Copy(revision.definition, definition)
+	revision.tags = copyTags(input.Tags)
+	f.taskDefinitions[family] = append(f.taskDefinitions[family], revision)
+
+	return &ecs.RegisterTaskDefinitionOutput{TaskDefinition: definition}, nil
+}
+
+func (f *fakeECS) DescribeTaskDefinition(input *ecs.DescribeTaskDefinitionInput) (*ecs.DescribeTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("DescribeTaskDefinition")
+
+	revision, err := f.revision(aws.StringValue(input.TaskDefinition))
+	if err != nil {
+		return nil, err
+	}
+	out := &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{}}
+	awsutil.Copy(out.TaskDefinition, revision.definition)
+	if len(input.Include) > 0 {
+		out.Tags = copyTags(revision.tags)
+	}
+	return out, nil
+}
+
+func (f *fakeECS) ListTaskDefinitionsPages(input *ecs.ListTaskDefinitionsInput, fn func(*ecs.ListTaskDefinitionsOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("ListTaskDefinitions")
+
+	revisions := []*fakeRevision{}
+	for family, familyRevisions := range f.taskDefinitions {
+		if !strings.HasPrefix(family, aws.StringValue(input.FamilyPrefix)) {
+			continue
+		}
+		for _, revision := range familyRevisions {
+			if input.Status == nil || aws.StringValue(revision.definition.Status) == aws.StringValue(input.Status) {
+				revisions = append(revisions, revision)
+			}
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		a, b := revisions[i].definition, revisions[j].definition
+		if aws.StringValue(a.Family) != aws.StringValue(b.Family) {
+			return aws.StringValue(a.Family) < aws.StringValue(b.Family)
+		}
+		if aws.StringValue(input.Sort) == ecs.SortOrderDesc {
+			return aws.Int64Value(a.Revision) > aws.Int64Value(b.Revision)
+		}
+		return aws.Int64Value(a.Revision) < aws.Int64Value(b.Revision)
+	})
+
+	out := &ecs.ListTaskDefinitionsOutput{}
+	for _, revision := range revisions {
+		out.TaskDefinitionArns = append(out.TaskDefinitionArns, revision.definition.TaskDefinitionArn)
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeECS) DeregisterTaskDefinition(input *ecs.DeregisterTaskDefinitionInput) (*ecs.DeregisterTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("DeregisterTaskDefinition")
+
+	revision, err := f.revision(aws.StringValue(input.TaskDefinition))
+	if err != nil {
+		return nil, err
+	}
+	revision.definition.Status = aws.String(ecs.TaskDefinitionStatusInactive)
+	return &ecs.DeregisterTaskDefinitionOutput{TaskDefinition: revision.definition}, nil
+}
+
+// addService creates a service running the task definition
+func (f *fakeECS) addService(name string, taskDefinition string) {
+	f.services[name] = &ecs.Service{
+		ServiceName:    aws.String(name),
+		ServiceArn:     aws.String(fakeArnPrefix + "service/" + name),
+		TaskDefinition: aws.String(taskDefinition),
+		Status:         aws.String("ACTIVE"),
+		Deployments: []*ecs.Deployment{{
+			Status:         aws.String("PRIMARY"),
+			TaskDefinition: aws.String(taskDefinition),
+		}},
+	}
+}
+
+func (f *fakeECS) service(name string) *ecs.Service {
+	return f.services[revisionName(name)]
+}
+
+func (f *fakeECS) ListServicesPages(input *ecs.ListServicesInput, fn func(*ecs.ListServicesOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("ListServices")
+
+	out := &ecs.ListServicesOutput{}
+	for _, service := range f.services {
+		out.ServiceArns = append(out.ServiceArns, service.ServiceArn)
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeECS) DescribeServices(input *ecs.DescribeServicesInput) (*ecs.DescribeServicesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("DescribeServices")
+
+	out := &ecs.DescribeServicesOutput{}
+	for _, name := range input.Services {
+		service := f.service(aws.StringValue(name))
+		if service == nil {
+			out.Failures = append(out.Failures, &ecs.Failure{Arn: name, Reason: aws.String("MISSING")})
+			continue
+		}
+		copied := &ecs.Service{}
+		awsutil.Copy(copied, service)
+		out.Services = append(out.Services, copied)
+	}
+	return out, nil
+}
+
+func (f *fakeECS) UpdateService(input *ecs.UpdateServiceInput) (*ecs.UpdateServiceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.call("UpdateService")
+	f.updateInputs = append(f.updateInputs, input)
+
+	service := f.service(aws.StringValue(input.Service))
+	if service == nil {
+		return nil, awserr.New(ecs.ErrCodeServiceNotFoundException, "Service not found.", nil)
+	}
+	if input.TaskDefinition != nil {
+		service.Deployments = append([]*ecs.Deployment{{
+			Status:         aws.String("PRIMARY"),
+			TaskDefinition: input.TaskDefinition,
+		}}, service.Deployments...)
+		service.TaskDefinition = input.TaskDefinition
+	}
+	return &ecs.UpdateServiceOutput{Service: service}, nil
+}
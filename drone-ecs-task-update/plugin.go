@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
 )
 
 type Plugin struct {
@@ -25,7 +26,9 @@ type Plugin struct {
 	Cluster            string
 	IgnoreMissing      bool
 	ForceNewDeployment bool
-	ecsService         *ecs.ECS
+	KeepRevisions      int64
+	PruneDryRun        bool
+	ecsService         ecsiface.ECSAPI
 }
 
 func (p *Plugin) Exec() error {
@@ -36,7 +39,10 @@ func (p *Plugin) Exec() error {
 		log.Fatal("You need to provide both cluster and service parameters")
 	}
 
-	p.Connect()
+	// Clients are injected in tests
+	if p.ecsService == nil {
+		p.Connect()
+	}
 
 	var err error
 
@@ -266,6 +272,14 @@ func (p *Plugin) UpdateServiceWithImage(taskDefinition ecs.TaskDefinition, tags
 	}
 	fmt.Println("Updated Service: ")
 	fmt.Println(updatedService)
+
+	if p.KeepRevisions > 0 {
+		log.Printf("Pruning revisions, keeping the newest %d\n", p.KeepRevisions)
+		if err := p.pruneRevisions(*taskDefinition.Family, newTaskDefinitionArn, p.PruneDryRun); err != nil {
+			// The service is already updated, so pruning doesn't fail the step
+			log.Println("Error pruning revisions: " + err.Error())
+		}
+	}
 	return nil
 
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// newServiceFixture returns a fake ECS running the service `web` on
+// revision web:1, which has an `app` and a `proxy` container
+func newServiceFixture(t *testing.T) *fakeECS {
+	t.Helper()
+	fake := newFakeECS()
+	out, err := fake.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+		Family: aws.String("web"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("example/app:1")},
+			{Name: aws.String("proxy"), Image: aws.String("nginx:1.25")},
+		},
+		Tags: []*ecs.Tag{{Key: aws.String("team"), Value: aws.String("platform")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.addService("web", aws.StringValue(out.TaskDefinition.TaskDefinitionArn))
+	return fake
+}
+
+func TestExecRegistersRevisionWithNewTag(t *testing.T) {
+	fake := newServiceFixture(t)
+	p := &Plugin{Cluster: "main", Service: "web", ContainerName: "app", Tag: "2", ecsService: fake}
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	revision, err := fake.revision("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.Int64Value(revision.definition.Revision) != 2 {
+		t.Fatalf("expected revision 2 to be registered, got %d", aws.Int64Value(revision.definition.Revision))
+	}
+	images := map[string]string{}
+	for _, container := range revision.definition.ContainerDefinitions {
+		images[aws.StringValue(container.Name)] = aws.StringValue(container.Image)
+	}
+	if images["app"] != "example/app:2" || images["proxy"] != "nginx:1.25" {
+		t.Errorf("unexpected images %v", images)
+	}
+	if len(revision.tags) != 1 || aws.StringValue(revision.tags[0].Key) != "team" {
+		t.Errorf("expected the tags to be copied, got %v", revision.tags)
+	}
+	if got := aws.StringValue(fake.services["web"].TaskDefinition); got != aws.StringValue(revision.definition.TaskDefinitionArn) {
+		t.Errorf("expected the service to run the new revision, got %s", got)
+	}
+}
+
+func TestExecForcesDeploymentWhenImageIsUnchanged(t *testing.T) {
+	fake := newServiceFixture(t)
+	p := &Plugin{Cluster: "main", Service: "web", ContainerName: "app", DockerImage: "example/app", Tag: "1", ecsService: fake}
+
+	if err := p.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.calls["RegisterTaskDefinition"] != 1 {
+		t.Errorf("expected no new revision, got %d registrations", fake.calls["RegisterTaskDefinition"])
+	}
+	if len(fake.updateInputs) != 1 || !aws.BoolValue(fake.updateInputs[0].ForceNewDeployment) {
+		t.Errorf("expected a forced deployment, got %v", fake.updateInputs)
+	}
+}
+
+func TestUpdateServiceWithImage(t *testing.T) {
+	fake := newServiceFixture(t)
+	p := &Plugin{Cluster: "main", Service: "web", ecsService: fake}
+
+	definition := ecs.TaskDefinition{
+		Family: aws.String("web"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("example/app:3")},
+		},
+	}
+	if err := p.UpdateServiceWithImage(definition, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.updateInputs) != 1 {
+		t.Fatalf("expected one UpdateService call, got %d", len(fake.updateInputs))
+	}
+	if got := aws.StringValue(fake.updateInputs[0].TaskDefinition); got != fakeArnPrefix+"task-definition/web:2" {
+		t.Errorf("expected the service to be updated to web:2, got %s", got)
+	}
+}
+
+func TestUpdateServiceWithImageFailsOnMissingService(t *testing.T) {
+	fake := newServiceFixture(t)
+	p := &Plugin{Cluster: "main", Service: "api", ecsService: fake}
+
+	definition := ecs.TaskDefinition{
+		Family: aws.String("web"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{Name: aws.String("app"), Image: aws.String("example/app:3")},
+		},
+	}
+	if err := p.UpdateServiceWithImage(definition, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestUpdateServiceWithImagePrunesRevisions(t *testing.T) {
+	fake := newServiceFixture(t)
+	for _, image := range []string{"example/app:2", "example/app:3"} {
+		if _, err := fake.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+			Family:               aws.String("web"),
+			ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("app"), Image: aws.String(image)}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &Plugin{Cluster: "main", Service: "web", KeepRevisions: 2, ecsService: fake}
+
+	definition := ecs.TaskDefinition{
+		Family:               aws.String("web"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("app"), Image: aws.String("example/app:4")}},
+	}
+	if err := p.UpdateServiceWithImage(definition, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	inactive := []string{}
+	for _, revision := range fake.taskDefinitions["web"] {
+		if aws.StringValue(revision.definition.Status) == ecs.TaskDefinitionStatusInactive {
+			inactive = append(inactive, revisionName(aws.StringValue(revision.definition.TaskDefinitionArn)))
+		}
+	}
+	// web:1 is kept as the previous deployment of the service still uses it
+	if len(inactive) != 1 || inactive[0] != "web:2" {
+		t.Errorf("expected only web:2 to be deregistered, got %v", inactive)
+	}
+}